@@ -0,0 +1,81 @@
+package bot
+
+import (
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"syscall"
+
+	"github.com/komon/gosukebot/transport"
+)
+
+// exitAction is what Run does once the bot has stopped taking messages
+type exitAction int
+
+const (
+	exitShutdown exitAction = iota
+	exitRestart
+)
+
+var adminCommand = regexp.MustCompile(`^jojo[\t ]+(shutdown|restart|reload)[.!]*$`)
+
+// admin runs msg as an admin command if it is one, and reports whether it
+// was. Only users listed in the admins setting get to run them, and never
+// over transports where anybody could be using their name
+func (p *pool) admin(msg transport.Message) bool {
+	m := adminCommand.FindStringSubmatch(strings.TrimSpace(msg.Text))
+	if m == nil {
+		return false
+	}
+	if msg.Unverified {
+		p.logger.Warn("refused admin command from unverified user", "command", m[1], "user", msg.User)
+		p.reply(msg, transport.Response{Text: "Sorry, admin commands can't be trusted from here"}, false)
+		return true
+	}
+	if !p.settings.Admins[msg.User] {
+		p.logger.Warn("refused admin command", "command", m[1], "user", msg.User)
+		p.reply(msg, transport.Response{Text: "Sorry, only admins can do that"}, false)
+		return true
+	}
+
+	p.logger.Info("admin command", "command", m[1], "user", msg.User)
+	switch m[1] {
+	case "shutdown":
+		p.reply(msg, transport.Response{Text: "Shutting down..."}, false)
+		p.exit(exitShutdown)
+	case "restart":
+		p.reply(msg, transport.Response{Text: "Be right back"}, false)
+		p.exit(exitRestart)
+	case "reload":
+		if err := p.handlers.reload(); err != nil {
+			p.reply(msg, transport.Response{Text: "Reload failed: " + err.Error()}, false)
+		} else {
+			p.reply(msg, transport.Response{Text: "Reloaded"}, false)
+		}
+	}
+	return true
+}
+
+// alert tells every admin about text, in a direct message where the
+// transport can send one
+func (p *pool) alert(text string) {
+	ids := make([]string, 0, len(p.settings.Admins))
+	for id := range p.settings.Admins {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	p.logger.Info("alerting admins", "text", text)
+	for _, id := range ids {
+		p.reply(transport.Message{User: id, Channel: id}, transport.Response{Text: text}, false)
+	}
+}
+
+// restart replaces the running process with a fresh copy of itself
+func restart() error {
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	return syscall.Exec(exe, os.Args, os.Environ())
+}
@@ -1,55 +1,207 @@
 package bot
 
 import (
+	"context"
 	"fmt"
-	"log"
+	"log/slog"
+	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
 
+	"github.com/komon/gosukebot/config"
 	"github.com/komon/gosukebot/handler"
-	"github.com/nlopes/slack"
+	_ "github.com/komon/gosukebot/handler/mtgsearch"
+	_ "github.com/komon/gosukebot/handler/mtgstats"
+	_ "github.com/komon/gosukebot/handler/responders"
+	"github.com/komon/gosukebot/logging"
+	"github.com/komon/gosukebot/metrics"
+	"github.com/komon/gosukebot/transport"
+	"github.com/komon/gosukebot/transport/discord"
+	"github.com/komon/gosukebot/transport/eventsapi"
+	"github.com/komon/gosukebot/transport/irc"
+	"github.com/komon/gosukebot/transport/outbox"
+	"github.com/komon/gosukebot/transport/slackrtm"
+	"github.com/komon/gosukebot/transport/term"
 )
 
-//Run is the main operation of the bot, we set up a new slack api conn
-// and start receiving and sending messages
-func Run() int {
-	api := slack.New(slackToken)
+//Run is the main operation of the bot, we read the config file, set up
+// a new connection using the transport named by mode (or the config file
+// if mode is empty) and start receiving and sending messages until an
+// admin or a signal tells us to stop
+func Run(mode string) int {
+	cfg, err := config.Load(envOr("JOJO_CONFIG", "gosukebot.toml"))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error reading config: %v\n", err)
+		return 1
+	}
+	if mode != "" {
+		cfg.Transport = mode
+	}
+	if cfg.Transport == "repl" {
+		cfg = forRepl(cfg)
+	}
 
-	logger, err := loggerSetup()
+	logger, logFile, err := logging.New(cfg.Log)
 	if err != nil {
+		fmt.Fprintf(os.Stderr, "error setting up logging: %v\n", err)
 		return 1
 	}
-	handler.Init()
-	slack.SetLogger(logger)
-	rtm := api.NewRTM()
-	go rtm.ManageConnection()
+	defer logFile.Close()
 
-	for {
-		event := <-rtm.IncomingEvents
-		if event.Type == "message" {
-			msg := event.Data.(*slack.MessageEvent)
-			resp, err := handler.Handle(msg.Text)
-			if err != nil {
-				logger.Printf("message handle error: %v", err)
-				rtm.SendMessage(rtm.NewOutgoingMessage(err.Error(), msg.Channel))
-				if err.Error() == "shutdown" {
-					break
-				}
-			}
-			rtm.SendMessage(rtm.NewOutgoingMessage(resp, msg.Channel))
+	if err := handler.Init(cfg, logger); err != nil {
+		logger.Error("handler setup error", "err", err)
+		return 1
+	}
+	defer handler.Close()
+
+	t, err := newTransport(cfg, logger.With("transport", cfg.Transport))
+	if err != nil {
+		logger.Error("transport setup error", "err", err)
+		return 1
+	}
+	if err := t.Connect(); err != nil {
+		logger.Error("transport connect error", "err", err)
+		return 1
+	}
+	if cfg.MetricsAddr != "" {
+		srv, err := serveMetrics(cfg.MetricsAddr, t, logger)
+		if err != nil {
+			logger.Error("metrics server error", "err", err)
+			return 1
 		}
+		defer srv.Close()
+	}
+
+	s := settings{
+		Workers: cfg.Workers,
+		Timeout: cfg.Timeout.Duration,
+		Threads: cfg.Threading,
+		Admins:  map[string]bool{},
+		Bots:    cfg.Bots,
+	}
+	for _, id := range cfg.Admins {
+		s.Admins[id] = true
 	}
 
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(sigs)
+
+	h := handlers{handle: handler.Handle, reload: handler.Reload}
+	p := newPool(t, h, logger, s)
+	handler.SetAlert(p.alert)
+	defer handler.SetAlert(nil)
+	if serve(t, p, sigs, logger) == exitRestart {
+		logger.Info("restarting")
+		handler.Close()
+		logFile.Close()
+		if err := restart(); err != nil {
+			fmt.Fprintf(os.Stderr, "restart error: %v\n", err)
+			return 1
+		}
+	}
 	return 0
 }
 
-func loggerSetup() (*log.Logger, error) {
-	f, err := os.OpenFile("jojolog", os.O_RDWR|os.O_CREATE|os.O_APPEND, 0666)
+// newTransport returns the transport named in the config, Slack ones
+// behind an outbox so replies are paced and split the way Slack wants
+func newTransport(cfg config.Config, logger *slog.Logger) (transport.Transport, error) {
+	switch cfg.Transport {
+	case "rtm":
+		return outbox.New(slackrtm.New(cfg.Slack.Token, logger), outbox.Slack, logger), nil
+	case "events":
+		t := eventsapi.NewHTTP(cfg.Slack.EventsAddr, cfg.Slack.SigningSecret, cfg.Slack.Token, logger)
+		return outbox.New(t, outbox.Slack, logger), nil
+	case "socketmode":
+		t := eventsapi.NewSocketMode(cfg.Slack.AppToken, cfg.Slack.Token, logger)
+		return outbox.New(t, outbox.Slack, logger), nil
+	case "discord":
+		return discord.New(cfg.Discord.Token, logger)
+	case "irc":
+		return irc.New(irc.Config{
+			Server:           cfg.IRC.Server,
+			TLS:              cfg.IRC.TLS,
+			Nick:             cfg.IRC.Nick,
+			Password:         cfg.IRC.Password,
+			SASLPassword:     cfg.IRC.SASLPassword,
+			NickServPassword: cfg.IRC.NickServPassword,
+			Channels:         cfg.IRC.Channels,
+		}, logger), nil
+	case "repl":
+		return term.New(os.Stdin, os.Stdout), nil
+	}
+	return nil, fmt.Errorf("unknown transport %q", cfg.Transport)
+}
 
-	if err != nil {
-		fmt.Printf("error opening logfile: %v", err)
-		return nil, err
+// forRepl adjusts cfg for the repl, where it's just somebody trying
+// things out at a terminal: nothing is rate limited, and the logs go to
+// stderr where they can see them instead of to the log file
+func forRepl(cfg config.Config) config.Config {
+	cfg.Limits.User = config.Bucket{}
+	cfg.Limits.Channel = config.Bucket{}
+	cfg.Limits.Global = config.Bucket{}
+	cfg.Limits.Cooldowns = nil
+	cfg.Log.File = "stderr"
+	return cfg
+}
+
+// serveMetrics starts serving /metrics, and /healthz checking on t and
+// the handlers
+func serveMetrics(addr string, t transport.Transport, logger *slog.Logger) (*http.Server, error) {
+	metrics.Check("transport", func(context.Context) error {
+		if c, ok := t.(transport.Checker); ok {
+			return c.Check()
+		}
+		return nil
+	})
+	metrics.Check("handlers", handler.Check)
+	logger.Info("serving metrics", "addr", addr)
+	return metrics.Serve(addr, logger)
+}
+
+// serve reads messages off of the transport and hands them to the worker
+// pool until the transport is closed, an admin asks us to stop, or we get
+// a signal. No more messages are read once it's told to stop, but the
+// ones already handed over are finished before it returns what to do
+// next
+func serve(t transport.Transport, p *pool, sigs <-chan os.Signal, logger *slog.Logger) exitAction {
+	stop := func(sig os.Signal) {
+		logger.Info("shutting down", "signal", sig)
+		p.exit(exitShutdown)
 	}
+loop:
+	for {
+		// stopping comes first, even when there are messages waiting
+		select {
+		case sig := <-sigs:
+			stop(sig)
+			break loop
+		case <-p.stopped:
+			break loop
+		default:
+		}
+		select {
+		case msg, ok := <-t.Messages():
+			if !ok {
+				break loop
+			}
+			p.submit(msg)
+		case sig := <-sigs:
+			stop(sig)
+			break loop
+		case <-p.stopped:
+			break loop
+		}
+	}
+	p.wait()
+	t.Close()
+	return p.action
+}
 
-	defer f.Close()
-	return log.New(f, "jojobot: ", log.Lshortfile|log.LstdFlags), nil
+func envOr(key, fallback string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return fallback
 }
@@ -0,0 +1,17 @@
+package bot
+
+import (
+	"testing"
+
+	"github.com/komon/gosukebot/config"
+)
+
+func TestForRepl(t *testing.T) {
+	cfg := forRepl(config.Default())
+	if cfg.Limits.User.Burst != 0 || cfg.Limits.Channel.Burst != 0 || cfg.Limits.Global.Burst != 0 {
+		t.Errorf("expected no rate limits in the repl, got %+v", cfg.Limits)
+	}
+	if cfg.Log.File != "stderr" {
+		t.Errorf("expected the repl to log to stderr, got %q", cfg.Log.File)
+	}
+}
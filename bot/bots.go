@@ -0,0 +1,66 @@
+package bot
+
+import (
+	"sync"
+	"time"
+
+	"github.com/komon/gosukebot/config"
+	"github.com/komon/gosukebot/transport"
+)
+
+// bots decides which messages from other bots get handled. If answering
+// them is turned on it keeps count of how many have been handled in each
+// channel lately, so two bots answering each other run out of replies
+// instead of going on forever
+type bots struct {
+	cfg   config.Bots
+	users map[string]bool
+
+	mu      sync.Mutex
+	handled map[string][]time.Time
+	now     func() time.Time
+}
+
+func newBots(cfg config.Bots) *bots {
+	b := &bots{
+		cfg:     cfg,
+		users:   map[string]bool{},
+		handled: map[string][]time.Time{},
+		now:     time.Now,
+	}
+	for _, u := range cfg.Users {
+		b.users[u] = true
+	}
+	return b
+}
+
+// is reports whether msg came from a bot
+func (b *bots) is(msg transport.Message) bool {
+	return msg.Bot || b.users[msg.User]
+}
+
+// allow reports whether a bot's message in channel can be handled, and if
+// it can counts it against the channel's window
+func (b *bots) allow(channel string) bool {
+	if !b.cfg.Answer {
+		return false
+	}
+	if b.cfg.MaxReplies <= 0 {
+		return true
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	now := b.now()
+	recent := b.handled[channel][:0]
+	for _, t := range b.handled[channel] {
+		if now.Sub(t) < b.cfg.Window.Duration {
+			recent = append(recent, t)
+		}
+	}
+	if len(recent) >= b.cfg.MaxReplies {
+		b.handled[channel] = recent
+		return false
+	}
+	b.handled[channel] = append(recent, now)
+	return true
+}
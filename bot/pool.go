@@ -0,0 +1,218 @@
+package bot
+
+import (
+	"context"
+	"hash/fnv"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/komon/gosukebot/config"
+	"github.com/komon/gosukebot/metrics"
+	"github.com/komon/gosukebot/transport"
+)
+
+var (
+	messagesSeen = metrics.NewCounter("gosukebot_messages_total",
+		"Messages received from the transport.")
+	responsesSent = metrics.NewCounter("gosukebot_responses_total",
+		"Responses sent back over the transport.")
+	errorCount = metrics.NewCounter("gosukebot_errors_total",
+		"Errors handling messages or sending responses.", "kind")
+)
+
+// how many messages' answers are remembered as revisable
+const revisableTracked = 1000
+
+// handlers is what the pool runs messages through, it's the handler
+// package outside of tests
+type handlers struct {
+	handle func(context.Context, transport.Message) (transport.Response, error)
+	reload func() error
+}
+
+// settings tune how the pool runs messages
+type settings struct {
+	// Workers is how many messages can be handled at once
+	Workers int
+	// Timeout is how long a handler gets before its context is cancelled
+	Timeout time.Duration
+	Threads config.Threading
+	// Admins are the user IDs allowed to run admin commands
+	Admins map[string]bool
+	Bots   config.Bots
+}
+
+// pool runs messages through the handlers on a fixed number of workers so
+// a slow query in one channel doesn't hold up every other channel.
+// Messages from the same channel always go to the same worker, so replies
+// in a channel come back in the order the messages were sent
+type pool struct {
+	t        transport.Transport
+	handlers handlers
+	logger   *slog.Logger
+	settings settings
+	bots     *bots
+	// revisable remembers which messages got an answer that an edit can
+	// change or take back, answers from other handlers stay put
+	revisable *transport.Tracker
+
+	queues  []chan transport.Message
+	wg      sync.WaitGroup
+	stopped chan struct{}
+	once    sync.Once
+	action  exitAction
+}
+
+// newPool starts workers that run each message through h and send their
+// responses back over t
+func newPool(t transport.Transport, h handlers, logger *slog.Logger, s settings) *pool {
+	if s.Workers < 1 {
+		s.Workers = 1
+	}
+	p := &pool{
+		t:         t,
+		handlers:  h,
+		logger:    logger,
+		settings:  s,
+		bots:      newBots(s.Bots),
+		revisable: transport.NewTracker(revisableTracked),
+		queues:    make([]chan transport.Message, s.Workers),
+		stopped:   make(chan struct{}),
+	}
+	if q, ok := t.(transport.Queuer); ok {
+		q.Sent(p.sent)
+	}
+	for i := range p.queues {
+		p.queues[i] = make(chan transport.Message, 16)
+		p.wg.Add(1)
+		go p.work(p.queues[i])
+	}
+	return p
+}
+
+// submit queues msg on the worker for its channel, blocking if that
+// worker is backed up
+func (p *pool) submit(msg transport.Message) {
+	h := fnv.New32a()
+	h.Write([]byte(msg.Channel))
+	p.queues[h.Sum32()%uint32(len(p.queues))] <- msg
+}
+
+// exit tells serve to stop taking messages, the first action asked for
+// is the one that sticks
+func (p *pool) exit(action exitAction) {
+	p.once.Do(func() {
+		p.action = action
+		close(p.stopped)
+	})
+}
+
+// wait lets the workers finish whatever's queued and waits for them, no
+// more messages can be submitted afterwards
+func (p *pool) wait() {
+	for _, q := range p.queues {
+		close(q)
+	}
+	p.wg.Wait()
+}
+
+func (p *pool) work(queue <-chan transport.Message) {
+	defer p.wg.Done()
+	for msg := range queue {
+		p.run(msg)
+	}
+}
+
+func (p *pool) run(msg transport.Message) {
+	messagesSeen.Inc()
+	if msg.Deleted {
+		p.retract(msg)
+		return
+	}
+	if p.bots.is(msg) && !p.bots.allow(msg.Channel) {
+		p.logger.Debug("ignoring bot", "channel", msg.Channel, "user", msg.User)
+		return
+	}
+	if !msg.Edited && msg.Command == "" && p.admin(msg) {
+		return
+	}
+
+	if n, ok := p.t.(transport.Namer); ok {
+		n.Name(&msg)
+	}
+
+	ctx, cancel := context.Background(), context.CancelFunc(func() {})
+	if p.settings.Timeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, p.settings.Timeout)
+	}
+	defer cancel()
+
+	// only an answer from a handler that follows edits gets changed, any
+	// other answer stays put and a new one goes after it
+	revisable := msg.Edited && p.revisable.Take(msg.Channel, msg.Timestamp) != nil
+
+	start := time.Now()
+	resp, err := p.handlers.handle(ctx, msg)
+	logger := p.logger.With("channel", msg.Channel, "user", msg.User, "latency", time.Since(start))
+	if err != nil {
+		logger.Error("message handle error", "err", err)
+		errorCount.Inc("handle")
+		p.reply(msg, transport.Response{Text: err.Error()}, false)
+	} else if resp.Text != "" {
+		logger.Info("handled message", "edited", msg.Edited)
+	}
+	switch {
+	case resp.Text != "":
+		p.reply(msg, resp, revisable)
+	case revisable && err == nil:
+		// the edit took away whatever we were answering
+		p.retract(msg)
+	case revisable:
+		// the earlier answer is still there to be revised next time
+		p.revisable.Add(msg.Channel, msg.Timestamp, msg.Timestamp)
+	}
+}
+
+// reply sends resp in reply to msg, replacing the earlier answer if edit
+// is set
+func (p *pool) reply(msg transport.Message, resp transport.Response, edit bool) {
+	r := replyTo(p.settings.Threads, msg, resp)
+	var err error
+	if ed, ok := p.t.(transport.Editor); ok && edit {
+		err = ed.Edit(r)
+	} else {
+		err = p.t.Send(r)
+	}
+	if _, queued := p.t.(transport.Queuer); queued && err == nil {
+		// it hasn't gone out yet, the transport calls sent once it has
+		return
+	}
+	p.sent(r, err)
+}
+
+// sent records how sending r went, once it's really been sent. Only an
+// answer that made it can be revised later
+func (p *pool) sent(r transport.Reply, err error) {
+	if err != nil {
+		p.logger.Error("send error", "channel", r.Channel, "err", err)
+		errorCount.Inc("send")
+		return
+	}
+	if r.Revisable {
+		p.revisable.Add(r.Channel, r.Source, r.Source)
+	}
+	responsesSent.Inc()
+}
+
+// retract deletes our reply to msg, if the transport can
+func (p *pool) retract(msg transport.Message) {
+	ed, ok := p.t.(transport.Editor)
+	if !ok {
+		return
+	}
+	if err := ed.Delete(msg.Channel, msg.Timestamp); err != nil {
+		p.logger.Error("delete error", "channel", msg.Channel, "err", err)
+		errorCount.Inc("delete")
+	}
+}
@@ -0,0 +1,304 @@
+package bot
+
+import (
+	"context"
+	"errors"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/komon/gosukebot/config"
+	"github.com/komon/gosukebot/logging"
+	"github.com/komon/gosukebot/transport"
+	"github.com/komon/gosukebot/transport/local"
+)
+
+var testLogger = logging.Discard()
+
+func echo(ctx context.Context, msg transport.Message) (transport.Response, error) {
+	return transport.Response{Text: msg.Text}, nil
+}
+
+func TestPoolKeepsChannelOrder(t *testing.T) {
+	tr := local.New()
+	// later messages finish faster, so without per channel ordering the
+	// replies would come back reversed
+	handle := func(ctx context.Context, msg transport.Message) (transport.Response, error) {
+		n, _ := strconv.Atoi(msg.Text)
+		time.Sleep(time.Duration(5-n) * 5 * time.Millisecond)
+		return transport.Response{Text: msg.Text}, nil
+	}
+	p := newPool(tr, handlers{handle: handle}, testLogger, settings{Workers: 4})
+	for i := 0; i < 5; i++ {
+		p.submit(transport.Message{Text: strconv.Itoa(i), Channel: "C1"})
+	}
+	p.wait()
+
+	sent := tr.Sent()
+	if len(sent) != 5 {
+		t.Fatalf("expected 5 replies, got %d", len(sent))
+	}
+	for i, r := range sent {
+		if r.Text != strconv.Itoa(i) {
+			t.Errorf("reply %d out of order: %q", i, r.Text)
+		}
+	}
+}
+
+func TestPoolTimeout(t *testing.T) {
+	tr := local.New()
+	handle := func(ctx context.Context, msg transport.Message) (transport.Response, error) {
+		<-ctx.Done()
+		return transport.Response{}, ctx.Err()
+	}
+	p := newPool(tr, handlers{handle: handle}, testLogger, settings{Workers: 1, Timeout: 10 * time.Millisecond})
+	p.submit(transport.Message{Text: "#[[avg: cmc]]", Channel: "C1"})
+	p.wait()
+
+	sent := tr.Sent()
+	if len(sent) != 1 || sent[0].Text != context.DeadlineExceeded.Error() {
+		t.Errorf("expected a deadline exceeded reply, got %+v", sent)
+	}
+}
+
+func TestAdminCommands(t *testing.T) {
+	tr := local.New()
+	reloaded := false
+	h := handlers{handle: echo, reload: func() error { reloaded = true; return nil }}
+	p := newPool(tr, h, testLogger, settings{Workers: 1, Admins: map[string]bool{"UADMIN": true}})
+
+	done := make(chan exitAction)
+	go func() { done <- serve(tr, p, nil, testLogger) }()
+
+	tr.Receive(transport.Message{Text: "jojo shutdown", User: "USOMEONE", Channel: "C1"})
+	tr.Receive(transport.Message{Text: "jojo shutdown", User: "UADMIN", Channel: "C1", Unverified: true})
+	tr.Receive(transport.Message{Text: "jojo reload", User: "UADMIN", Channel: "C1"})
+	tr.Receive(transport.Message{Text: "jojo restart", User: "UADMIN", Channel: "C1"})
+	select {
+	case action := <-done:
+		if action != exitRestart {
+			t.Errorf("expected restart, got %v", action)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("serve didn't stop")
+	}
+
+	sent := tr.Sent()
+	if len(sent) != 4 || sent[0].Text != "Sorry, only admins can do that" ||
+		sent[1].Text != "Sorry, admin commands can't be trusted from here" || sent[2].Text != "Reloaded" {
+		t.Errorf("unexpected replies %+v", sent)
+	}
+	if !reloaded {
+		t.Error("reload wasn't run")
+	}
+}
+
+func TestAlertAdmins(t *testing.T) {
+	tr := local.New()
+	p := newPool(tr, handlers{handle: echo}, testLogger, settings{Workers: 1, Admins: map[string]bool{"UADMIN": true, "UBOSS": true}})
+	p.alert("responders.toml changed but didn't load")
+	p.wait()
+
+	sent := tr.Sent()
+	if len(sent) != 2 || sent[0].Channel != "UADMIN" || sent[1].Channel != "UBOSS" ||
+		sent[0].Text != "responders.toml changed but didn't load" {
+		t.Errorf("expected every admin to be told, got %+v", sent)
+	}
+}
+
+func TestServeDrainsOnSignal(t *testing.T) {
+	tr := local.New()
+	handled := make(chan struct{})
+	handle := func(ctx context.Context, msg transport.Message) (transport.Response, error) {
+		close(handled)
+		time.Sleep(20 * time.Millisecond)
+		return transport.Response{Text: "done"}, nil
+	}
+	sigs := make(chan os.Signal, 1)
+	p := newPool(tr, handlers{handle: handle}, testLogger, settings{Workers: 1})
+
+	done := make(chan exitAction)
+	go func() { done <- serve(tr, p, sigs, testLogger) }()
+	tr.Receive(transport.Message{Text: "[[Lightning Bolt]]", Channel: "C1"})
+	<-handled
+	sigs <- syscall.SIGTERM
+
+	select {
+	case action := <-done:
+		if action != exitShutdown {
+			t.Errorf("expected shutdown, got %v", action)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("serve didn't stop")
+	}
+	if sent := tr.Sent(); len(sent) != 1 || sent[0].Text != "done" {
+		t.Errorf("in-flight message wasn't finished: %+v", sent)
+	}
+}
+
+func TestServeStopsReadingOnSignal(t *testing.T) {
+	tr := local.New()
+	sigs := make(chan os.Signal, 1)
+	sigs <- syscall.SIGINT
+	tr.Receive(transport.Message{Text: "[[Lightning Bolt]]", Channel: "C1"})
+	p := newPool(tr, handlers{handle: echo}, testLogger, settings{Workers: 1})
+
+	if action := serve(tr, p, sigs, testLogger); action != exitShutdown {
+		t.Errorf("expected shutdown, got %v", action)
+	}
+	if sent := tr.Sent(); len(sent) != 0 {
+		t.Errorf("expected nothing read after the signal, got %+v", sent)
+	}
+}
+
+func TestPoolFollowsEdits(t *testing.T) {
+	tr := local.New()
+	handle := func(ctx context.Context, msg transport.Message) (transport.Response, error) {
+		if msg.Text == "[[Lightning Blot]]" {
+			return transport.Response{Text: "Card Not Found!", Revisable: true}, nil
+		}
+		if msg.Text == "[[Lightning Bolt]]" {
+			return transport.Response{Text: "Lightning Bolt {R}", Revisable: true}, nil
+		}
+		// like the responders, which don't answer edits at all
+		if msg.Text == "hello jojo" && !msg.Edited {
+			return transport.Response{Text: "Hello to you too"}, nil
+		}
+		if msg.Text == "[[Za Warudo]]" {
+			return transport.Response{}, errors.New("database is locked")
+		}
+		return transport.Response{}, nil
+	}
+	p := newPool(tr, handlers{handle: handle}, testLogger, settings{Workers: 1})
+	p.submit(transport.Message{Text: "[[Lightning Blot]]", Channel: "C1", Timestamp: "1"})
+	p.submit(transport.Message{Text: "[[Lightning Bolt]]", Channel: "C1", Timestamp: "1", Edited: true})
+	p.submit(transport.Message{Text: "[[Lightning Bolt]]", Channel: "C1", Timestamp: "2"})
+	p.submit(transport.Message{Channel: "C1", Timestamp: "2", Deleted: true})
+	p.submit(transport.Message{Text: "[[Lightning Bolt]]", Channel: "C1", Timestamp: "3"})
+	p.submit(transport.Message{Text: "never mind", Channel: "C1", Timestamp: "3", Edited: true})
+	p.submit(transport.Message{Text: "hello jojo", Channel: "C1", Timestamp: "4"})
+	p.submit(transport.Message{Text: "hello jojo!", Channel: "C1", Timestamp: "4", Edited: true})
+	p.submit(transport.Message{Text: "hello jojo", Channel: "C1", Timestamp: "5"})
+	p.submit(transport.Message{Text: "[[Lightning Blot]]", Channel: "C1", Timestamp: "5", Edited: true})
+	p.submit(transport.Message{Text: "[[Lightning Bolt]]", Channel: "C1", Timestamp: "5", Edited: true})
+	p.submit(transport.Message{Text: "[[Lightning Bolt]]", Channel: "C1", Timestamp: "6"})
+	p.submit(transport.Message{Text: "[[Za Warudo]]", Channel: "C1", Timestamp: "6", Edited: true})
+	p.wait()
+
+	var got []string
+	for _, r := range tr.Sent() {
+		got = append(got, r.Source+": "+r.Text)
+	}
+	want := []string{
+		"1: Lightning Bolt {R}",
+		"4: Hello to you too",
+		"5: Hello to you too",
+		"5: Lightning Bolt {R}",
+		"6: Lightning Bolt {R}",
+		"6: database is locked",
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("expected only answers that follow edits to change, got %q", got)
+	}
+}
+
+// namer is a local transport that knows everyone's name
+type namer struct {
+	*local.Transport
+}
+
+func (namer) Name(msg *transport.Message) {
+	msg.UserName = "Jotaro"
+}
+
+func TestPoolLooksUpNames(t *testing.T) {
+	tr := namer{local.New()}
+	handle := func(ctx context.Context, msg transport.Message) (transport.Response, error) {
+		return transport.Response{Text: "Hello to you too, " + msg.UserName}, nil
+	}
+	p := newPool(tr, handlers{handle: handle}, testLogger, settings{Workers: 1})
+	p.submit(transport.Message{Text: "hello jojo", User: "U1", Channel: "C1"})
+	p.wait()
+
+	sent := tr.Sent()
+	if len(sent) != 1 || sent[0].Text != "Hello to you too, Jotaro" {
+		t.Errorf("expected the worker to look up the name, got %+v", sent)
+	}
+}
+
+// queued is a local transport that sends in the background and never
+// manages to
+type queued struct {
+	*local.Transport
+	sent func(transport.Reply, error)
+}
+
+func (q *queued) Sent(f func(transport.Reply, error)) {
+	q.sent = f
+}
+
+func (q *queued) Send(r transport.Reply) error {
+	q.sent(r, errors.New("channel_not_found"))
+	return nil
+}
+
+func TestPoolWaitsForQueuedSends(t *testing.T) {
+	tr := &queued{Transport: local.New()}
+	handle := func(ctx context.Context, msg transport.Message) (transport.Response, error) {
+		return transport.Response{Text: "Lightning Bolt {R}", Revisable: true}, nil
+	}
+	p := newPool(tr, handlers{handle: handle}, testLogger, settings{Workers: 1})
+	sent, errs := responsesSent.Value(), errorCount.Value("send")
+	p.run(transport.Message{Text: "[[Lightning Bolt]]", Channel: "C1", Timestamp: "1"})
+	p.wait()
+
+	if p.revisable.Take("C1", "1") != nil {
+		t.Error("expected a reply that never went out not to be revisable")
+	}
+	if responsesSent.Value() != sent || errorCount.Value("send") != errs+1 {
+		t.Error("expected the failed send to be counted as an error, not a response")
+	}
+}
+
+func TestPoolIgnoresBots(t *testing.T) {
+	tr := local.New()
+	p := newPool(tr, handlers{handle: echo}, testLogger, settings{Workers: 1, Bots: config.Bots{Users: []string{"UHUBOT"}}})
+	p.submit(transport.Message{Text: "[[Lightning Bolt]]", User: "B0OTHER", Channel: "C1", Bot: true})
+	p.submit(transport.Message{Text: "[[Lightning Bolt]]", User: "UHUBOT", Channel: "C1"})
+	p.submit(transport.Message{Text: "[[Shock]]", User: "USOMEONE", Channel: "C1"})
+	p.wait()
+
+	sent := tr.Sent()
+	if len(sent) != 1 || sent[0].Text != "[[Shock]]" {
+		t.Errorf("expected only the person to get a reply, got %+v", sent)
+	}
+}
+
+func TestPoolStopsBotLoops(t *testing.T) {
+	tr := local.New()
+	bots := config.Bots{Answer: true, MaxReplies: 2, Window: config.Duration{Duration: time.Minute}}
+	p := newPool(tr, handlers{handle: echo}, testLogger, settings{Workers: 1, Bots: bots})
+	now := time.Now()
+	p.bots.now = func() time.Time { return now }
+
+	for i := 0; i < 3; i++ {
+		p.run(transport.Message{Text: strconv.Itoa(i), User: "B0OTHER", Channel: "C1", Bot: true})
+	}
+	p.run(transport.Message{Text: "elsewhere", User: "B0OTHER", Channel: "C2", Bot: true})
+	p.run(transport.Message{Text: "person", User: "USOMEONE", Channel: "C1"})
+	now = now.Add(time.Minute)
+	p.run(transport.Message{Text: "later", User: "B0OTHER", Channel: "C1", Bot: true})
+	p.wait()
+
+	var got []string
+	for _, r := range tr.Sent() {
+		got = append(got, r.Text)
+	}
+	want := []string{"0", "1", "elsewhere", "person", "later"}
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("expected replies %v, got %v", want, got)
+	}
+}
@@ -0,0 +1,31 @@
+package bot
+
+import (
+	"strings"
+
+	"github.com/komon/gosukebot/config"
+	"github.com/komon/gosukebot/transport"
+)
+
+// replyTo addresses a response to msg, starting a new thread under it if
+// the channel's threading mode calls for one
+func replyTo(th config.Threading, msg transport.Message, resp transport.Response) transport.Reply {
+	r := transport.ReplyTo(msg, resp)
+	if r.Thread != "" {
+		return r
+	}
+
+	mode, ok := th.Channels[msg.Channel]
+	if !ok {
+		mode = th.Default
+	}
+	switch mode {
+	case "always":
+		r.Thread = msg.Timestamp
+	case "long":
+		if strings.Count(strings.TrimRight(resp.Text, "\n"), "\n")+1 > th.LongLines {
+			r.Thread = msg.Timestamp
+		}
+	}
+	return r
+}
@@ -0,0 +1,37 @@
+package bot
+
+import (
+	"testing"
+
+	"github.com/komon/gosukebot/config"
+	"github.com/komon/gosukebot/transport"
+)
+
+func TestReplyTo(t *testing.T) {
+	th := config.Threading{
+		Default:   "auto",
+		LongLines: 2,
+		Channels:  map[string]string{"CLONG": "long", "CALWAYS": "always"},
+	}
+	root := transport.Message{Channel: "CAUTO", Timestamp: "2.0"}
+	threaded := transport.Message{Channel: "CAUTO", Thread: "1.0", Timestamp: "2.0"}
+	long := "Bolt\nShock\nIncinerate\n"
+
+	cases := []struct {
+		msg    transport.Message
+		text   string
+		thread string
+	}{
+		{root, long, ""},
+		{threaded, "Bolt", "1.0"},
+		{transport.Message{Channel: "CLONG", Timestamp: "2.0"}, "Bolt\nShock\n", ""},
+		{transport.Message{Channel: "CLONG", Timestamp: "2.0"}, long, "2.0"},
+		{transport.Message{Channel: "CALWAYS", Timestamp: "2.0"}, "Bolt", "2.0"},
+		{transport.Message{Channel: "CALWAYS", Thread: "1.0", Timestamp: "2.0"}, "Bolt", "1.0"},
+	}
+	for _, c := range cases {
+		if r := replyTo(th, c.msg, transport.Response{Text: c.text}); r.Thread != c.thread {
+			t.Errorf("%s %q: expected thread %q, got %q", c.msg.Channel, c.text, c.thread, r.Thread)
+		}
+	}
+}
@@ -1,26 +1,54 @@
 package config
 
 import (
-	"log"
+	"fmt"
 
 	"github.com/BurntSushi/toml"
 )
 
+// responder is one entry in the responders file. Cooldown is how long it
+// waits before answering in the same channel again.
+//
+// Weights go with Responses in order, making some likelier than others,
+// responses without a weight weigh 1. NoRepeat keeps it from giving the
+// same response twice in a row in a channel. Chance is the percentage of
+// matches it answers, 0 meaning all of them. Hours, like "22:00-06:00",
+// and Days, like ["sat", "sun"], limit when it answers, in the bot's
+// local time
 type responder struct {
 	Regexp    string
 	Responses []string
+	Weights   []int
+	Cooldown  Duration
+	NoRepeat  bool `toml:"no_repeat"`
+	Chance    int
+	Hours     string
+	Days      []string
 }
 
 type responders struct {
 	Rs []responder `toml:"responders"`
 }
 
-// PopulateResponders reads the toml file "responders.toml" and returns
-// a slice of responders read from the config file
-func PopulateResponders() []responder {
+// PopulateResponders reads the responders toml file at path and returns
+// a slice of responders read from it
+func PopulateResponders(path string) ([]responder, error) {
 	var rs responders
-	if _, err := toml.DecodeFile("responders.toml", &rs); err != nil {
-		log.Fatalf("Error reading responders file: %v", err)
+	if _, err := toml.DecodeFile(path, &rs); err != nil {
+		return nil, fmt.Errorf("reading responders file: %v", err)
 	}
-	return rs.Rs
+	return rs.Rs, nil
+}
+
+// Threading says where replies should go: Channels maps a channel ID to
+// one of the modes below, channels that aren't listed use Default.
+//
+//	auto    reply in the thread of the triggering message, if it's in one
+//	long    like auto, but also start a thread for responses longer than
+//	        LongLines lines
+//	always  always reply in a thread
+type Threading struct {
+	Default   string
+	LongLines int `toml:"long_lines"`
+	Channels  map[string]string
 }
@@ -0,0 +1,238 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/BurntSushi/toml"
+	"github.com/komon/gosukebot/logging"
+)
+
+// Config is everything read from the config file, see
+// gosukebot.example.toml for what each setting does
+type Config struct {
+	Transport  string
+	Database   string
+	Responders string
+	Admins     []string
+	Workers    int
+	Timeout    Duration
+	// MetricsAddr is where /metrics and /healthz are served, if anywhere
+	MetricsAddr string `toml:"metrics_addr"`
+
+	Log       logging.Config
+	Threading Threading
+	Handlers  Handlers
+	Limits    Limits
+	Bots      Bots
+
+	Slack   Slack
+	Discord Discord
+	IRC     IRC `toml:"irc"`
+}
+
+// Handlers says which handlers run where: Channels maps a channel ID to
+// the handlers enabled in it, channels that aren't listed get Enabled,
+// and an empty Enabled means every handler. Dispatch is "all" to let
+// every matching handler respond, or "first" for just the highest
+// priority one
+type Handlers struct {
+	Enabled  []string
+	Channels map[string][]string
+	Dispatch string
+}
+
+// EnabledIn reports whether the named handler is enabled in channel
+func (h Handlers) EnabledIn(name, channel string) bool {
+	enabled, ok := h.Channels[channel]
+	if !ok {
+		enabled = h.Enabled
+		if len(enabled) == 0 {
+			return true
+		}
+	}
+	for _, e := range enabled {
+		if e == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Limits are applied to every handler's responses. Responses come out of
+// a bucket for the user, one for the channel and a global one, and each
+// handler can have a cooldown between responses in the same channel.
+// Notice tells users they're being limited instead of ignoring them.
+// Responses are cut off after MaxLength bytes (0 for no limit), and if
+// AllowChannels or AllowUsers aren't empty, only those channels or users
+// get responses at all
+type Limits struct {
+	User      Bucket
+	Channel   Bucket
+	Global    Bucket
+	Cooldowns map[string]Duration
+	Notice    bool
+
+	MaxLength     int      `toml:"max_length"`
+	AllowChannels []string `toml:"allow_channels"`
+	AllowUsers    []string `toml:"allow_users"`
+}
+
+// Bots says what to do about messages from other bots. They're ignored
+// unless Answer is set, and even then at most MaxReplies of them are
+// answered in a channel every Window, so two bots can't keep setting each
+// other off. Users lists IDs or nicks to treat as bots, for transports
+// that can't tell
+type Bots struct {
+	Answer     bool
+	MaxReplies int `toml:"max_replies"`
+	Window     Duration
+	Users      []string
+}
+
+// Bucket is a token bucket: Burst responses can go out at once, then one
+// more every Every. A Burst of 0 means no limit
+type Bucket struct {
+	Burst int
+	Every Duration
+}
+
+// Slack holds the credentials for the rtm, events and socketmode
+// transports
+type Slack struct {
+	Token         string
+	AppToken      string `toml:"app_token"`
+	SigningSecret string `toml:"signing_secret"`
+	EventsAddr    string `toml:"events_addr"`
+}
+
+// Discord holds the credentials for the discord transport
+type Discord struct {
+	Token string
+}
+
+// IRC holds the connection settings for the irc transport
+type IRC struct {
+	Server           string
+	TLS              bool `toml:"tls"`
+	Nick             string
+	Password         string
+	SASLPassword     string `toml:"sasl_password"`
+	NickServPassword string `toml:"nickserv_password"`
+	Channels         []string
+}
+
+// Duration is a time.Duration written like "30s" in the config file
+type Duration struct {
+	time.Duration
+}
+
+// UnmarshalText parses a duration string
+func (d *Duration) UnmarshalText(text []byte) error {
+	var err error
+	d.Duration, err = time.ParseDuration(string(text))
+	return err
+}
+
+// Default returns the config used when there's no config file
+func Default() Config {
+	return Config{
+		Transport:  "rtm",
+		Database:   "mtg.db",
+		Responders: "responders.toml",
+		Workers:    4,
+		Timeout:    Duration{30 * time.Second},
+		Log: logging.Config{
+			File:       "jojolog",
+			MaxSizeMB:  10,
+			MaxBackups: 5,
+		},
+		Threading: Threading{Default: "auto", LongLines: 5},
+		Handlers:  Handlers{Dispatch: "all"},
+		Limits: Limits{
+			User:    Bucket{Burst: 5, Every: Duration{10 * time.Second}},
+			Channel: Bucket{Burst: 20, Every: Duration{3 * time.Second}},
+		},
+		Bots:  Bots{MaxReplies: 5, Window: Duration{time.Minute}},
+		Slack: Slack{EventsAddr: ":3000"},
+	}
+}
+
+// Load reads the config file at path on top of the defaults, a missing
+// file just leaves the defaults alone. Environment variables override
+// whatever the file says, so secrets can be kept out of it
+func Load(path string) (Config, error) {
+	cfg := Default()
+	if _, err := os.Stat(path); err == nil {
+		if _, err := toml.DecodeFile(path, &cfg); err != nil {
+			return cfg, err
+		}
+	}
+	return cfg, cfg.applyEnv()
+}
+
+// applyEnv overrides settings with any environment variables that are set
+func (cfg *Config) applyEnv() error {
+	strs := map[string]*string{
+		"JOJO_TRANSPORT":        &cfg.Transport,
+		"JOJO_DATABASE":         &cfg.Database,
+		"JOJO_RESPONDERS":       &cfg.Responders,
+		"JOJO_LOG_LEVEL":        &cfg.Log.Level,
+		"JOJO_LOG_FORMAT":       &cfg.Log.Format,
+		"JOJO_LOG_FILE":         &cfg.Log.File,
+		"SLACK_TOKEN":           &cfg.Slack.Token,
+		"SLACK_APP_TOKEN":       &cfg.Slack.AppToken,
+		"SLACK_SIGNING_SECRET":  &cfg.Slack.SigningSecret,
+		"JOJO_EVENTS_ADDR":      &cfg.Slack.EventsAddr,
+		"JOJO_METRICS_ADDR":     &cfg.MetricsAddr,
+		"DISCORD_TOKEN":         &cfg.Discord.Token,
+		"IRC_SERVER":            &cfg.IRC.Server,
+		"IRC_NICK":              &cfg.IRC.Nick,
+		"IRC_PASSWORD":          &cfg.IRC.Password,
+		"IRC_SASL_PASSWORD":     &cfg.IRC.SASLPassword,
+		"IRC_NICKSERV_PASSWORD": &cfg.IRC.NickServPassword,
+	}
+	for key, s := range strs {
+		if v, ok := os.LookupEnv(key); ok {
+			*s = v
+		}
+	}
+
+	lists := map[string]*[]string{
+		"JOJO_ADMINS":   &cfg.Admins,
+		"JOJO_HANDLERS": &cfg.Handlers.Enabled,
+		"IRC_CHANNELS":  &cfg.IRC.Channels,
+	}
+	for key, l := range lists {
+		if v, ok := os.LookupEnv(key); ok {
+			*l = strings.FieldsFunc(v, func(r rune) bool {
+				return r == ',' || unicode.IsSpace(r)
+			})
+		}
+	}
+
+	if v, ok := os.LookupEnv("IRC_TLS"); ok {
+		tls, err := strconv.ParseBool(v)
+		if err != nil {
+			return fmt.Errorf("IRC_TLS: %v", err)
+		}
+		cfg.IRC.TLS = tls
+	}
+	if v, ok := os.LookupEnv("JOJO_WORKERS"); ok {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return fmt.Errorf("JOJO_WORKERS: %v", err)
+		}
+		cfg.Workers = n
+	}
+	if v, ok := os.LookupEnv("JOJO_TIMEOUT"); ok {
+		if err := cfg.Timeout.UnmarshalText([]byte(v)); err != nil {
+			return fmt.Errorf("JOJO_TIMEOUT: %v", err)
+		}
+	}
+	return nil
+}
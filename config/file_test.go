@@ -0,0 +1,44 @@
+package config
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLoad(t *testing.T) {
+	cfg, err := Load("../gosukebot.example.toml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Timeout.Duration != 30*time.Second || cfg.IRC.Server != "irc.libera.chat:6697" ||
+		cfg.Log.MaxSizeMB != 10 || cfg.Threading.LongLines != 5 {
+		t.Errorf("unexpected config %+v", cfg)
+	}
+
+	t.Setenv("SLACK_TOKEN", "xoxb-from-env")
+	t.Setenv("JOJO_ADMINS", "U1, U2")
+	cfg, err = Load("does-not-exist.toml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Slack.Token != "xoxb-from-env" || len(cfg.Admins) != 2 || cfg.Admins[1] != "U2" {
+		t.Errorf("environment didn't override config: %+v", cfg)
+	}
+	if cfg.Database != "mtg.db" {
+		t.Errorf("expected default database, got %q", cfg.Database)
+	}
+}
+
+func TestEnabledIn(t *testing.T) {
+	h := Handlers{Channels: map[string][]string{"CSTATS": {"mtgstats"}}}
+	if !h.EnabledIn("responders", "CGENERAL") {
+		t.Error("every handler should be enabled when Enabled is empty")
+	}
+	if h.EnabledIn("responders", "CSTATS") || !h.EnabledIn("mtgstats", "CSTATS") {
+		t.Error("channel list should override the default")
+	}
+	h.Enabled = []string{"mtgsearch"}
+	if h.EnabledIn("mtgstats", "CGENERAL") {
+		t.Error("mtgstats isn't in Enabled")
+	}
+}
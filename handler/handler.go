@@ -0,0 +1,366 @@
+// Package handler is what the bot runs every message through. Handlers
+// register themselves here from an init function, the same way
+// database/sql drivers do, and Handle asks each one that's enabled in the
+// message's channel whether it has anything to say
+package handler
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/komon/gosukebot/config"
+	"github.com/komon/gosukebot/transport"
+)
+
+// Handler picks out the parts of a message it cares about and responds to
+// them. Match is called on every message, so it should be cheap; Respond
+// is only called when Match found something, with whatever Match returned
+type Handler interface {
+	// Match returns the parts of msg to respond to, or nil if there
+	// aren't any
+	Match(msg string) []string
+	// Respond answers the matches found in msg. It should give up and
+	// return ctx.Err() once ctx is done
+	Respond(ctx context.Context, msg transport.Message, matches []string) (transport.Response, error)
+	// Close releases whatever the handler holds on to
+	Close() error
+}
+
+// Reviser is implemented by handlers whose responses should be redone
+// when the message they answered is edited, like card lookups fixing a
+// typo. Other handlers ignore edits
+type Reviser interface {
+	Revise() bool
+}
+
+// Reloader is implemented by handlers that can re-read their
+// configuration without restarting the bot
+type Reloader interface {
+	Reload() error
+}
+
+// Commander is implemented by handlers that can also be run as slash
+// commands. The text after the command is handed to Respond as the only
+// match, so it's written the same way as it would be in a message
+type Commander interface {
+	// Commands returns the names of the commands, without the slash
+	Commands() []string
+}
+
+// Checker is implemented by handlers that depend on something that can
+// go away, like a database, for health checks
+type Checker interface {
+	// Check returns an error saying what's wrong, or nil if the handler
+	// can do its job
+	Check(ctx context.Context) error
+}
+
+// Factory builds a handler from the bot's config
+type Factory func(cfg config.Config, logger *slog.Logger) (Handler, error)
+
+// Info describes a registered handler
+type Info struct {
+	// Name is what the handler is called in the config file
+	Name string
+	// Priority orders handlers, higher goes first
+	Priority int
+	// Description is a line about what the handler does
+	Description string
+}
+
+// Dispatch modes for config.Handlers.Dispatch
+const (
+	// DispatchAll lets every matching handler respond
+	DispatchAll = "all"
+	// DispatchFirst only lets the highest priority matching handler
+	// respond
+	DispatchFirst = "first"
+)
+
+type entry struct {
+	Info
+	factory Factory
+}
+
+type running struct {
+	Info
+	Handler
+	// respond is the handler's Respond wrapped in middleware
+	respond RespondFunc
+}
+
+var (
+	regMu    sync.Mutex
+	registry = map[string]entry{}
+
+	mu       sync.RWMutex
+	active   []running
+	handlers config.Handlers
+	log      = slog.Default()
+
+	alertMu sync.Mutex
+	alertTo func(text string)
+)
+
+// Register makes a handler available under name. It panics if called
+// twice with the same name or with a nil factory
+func Register(name string, priority int, description string, f Factory) {
+	regMu.Lock()
+	defer regMu.Unlock()
+	if f == nil {
+		panic("handler: Register factory is nil for " + name)
+	}
+	if _, dup := registry[name]; dup {
+		panic("handler: Register called twice for " + name)
+	}
+	registry[name] = entry{Info{name, priority, description}, f}
+}
+
+// Registered returns every registered handler in the order they run
+func Registered() []Info {
+	regMu.Lock()
+	defer regMu.Unlock()
+	infos := make([]Info, 0, len(registry))
+	for _, e := range registry {
+		infos = append(infos, e.Info)
+	}
+	sortInfos(infos)
+	return infos
+}
+
+// Init builds every registered handler that's enabled somewhere in cfg and
+// wraps them in the middleware cfg asks for. If one fails the ones
+// already built are closed again
+func Init(cfg config.Config, logger *slog.Logger) error {
+	var built []running
+	mws := middleware(cfg, logger)
+	for _, info := range Registered() {
+		if !enabledAnywhere(cfg.Handlers, info.Name) {
+			logger.Debug("handler disabled", "handler", info.Name)
+			continue
+		}
+		regMu.Lock()
+		f := registry[info.Name].factory
+		regMu.Unlock()
+
+		h, err := f(cfg, logger.With("handler", info.Name))
+		if err != nil {
+			closeAll(built)
+			return fmt.Errorf("%s: %v", info.Name, err)
+		}
+		built = append(built, running{info, h, chain(info, h.Respond, mws)})
+		logger.Debug("handler ready", "handler", info.Name, "priority", info.Priority)
+	}
+
+	mu.Lock()
+	old := active
+	active, handlers, log = built, cfg.Handlers, logger
+	mu.Unlock()
+	return closeAll(old)
+}
+
+// Handle runs msg through the handlers enabled in its channel, highest
+// priority first. Their responses are joined together, unless dispatch
+// is set to first, in which case only the first handler that matches
+// gets to respond. An ephemeral response is only returned if there's
+// nothing else to say. Edited messages only go to Revisers, and slash
+// commands only to the Commander they belong to. The response is marked
+// Revisable if a Reviser had something to say. However many handlers
+// respond, msg only counts once against the rate limits
+func Handle(ctx context.Context, msg transport.Message) (transport.Response, error) {
+	mu.RLock()
+	hs, cfg, logger := active, handlers, log
+	mu.RUnlock()
+	ctx = withCharge(ctx)
+	if msg.Command != "" {
+		return command(ctx, hs, cfg, msg)
+	}
+
+	var (
+		texts  []string
+		notes  []string
+		resp   transport.Response
+		notice *transport.Response
+	)
+	for _, h := range hs {
+		if !cfg.EnabledIn(h.Name, msg.Channel) {
+			continue
+		}
+		rv, ok := h.Handler.(Reviser)
+		revises := ok && rv.Revise()
+		if msg.Edited && !revises {
+			continue
+		}
+		matches := match(h, msg.Text, logger)
+		if len(matches) == 0 {
+			continue
+		}
+		r, err := h.respond(ctx, msg, matches)
+		switch {
+		case r.Ephemeral:
+			if notice == nil {
+				notice = &r
+			}
+		case r.Text != "":
+			texts = append(texts, strings.TrimRight(r.Text, "\n"))
+			if len(r.Cards) == 0 {
+				notes = append(notes, strings.TrimRight(r.Text, "\n"))
+			}
+			resp.Cards = append(resp.Cards, r.Cards...)
+			resp.Revisable = resp.Revisable || revises
+		}
+		if err != nil {
+			resp.Text, resp.Notes = strings.Join(texts, "\n"), strings.Join(notes, "\n")
+			return resp, fmt.Errorf("%s: %v", h.Name, err)
+		}
+		if cfg.Dispatch == DispatchFirst {
+			break
+		}
+	}
+	resp.Text, resp.Notes = strings.Join(texts, "\n"), strings.Join(notes, "\n")
+	if resp.Text == "" && len(resp.Cards) == 0 && notice != nil {
+		return *notice, nil
+	}
+	return resp, nil
+}
+
+// command runs msg through the enabled handler with its command
+func command(ctx context.Context, hs []running, cfg config.Handlers, msg transport.Message) (transport.Response, error) {
+	for _, h := range hs {
+		c, ok := h.Handler.(Commander)
+		if !ok || !cfg.EnabledIn(h.Name, msg.Channel) {
+			continue
+		}
+		for _, name := range c.Commands() {
+			if name != msg.Command {
+				continue
+			}
+			text := strings.TrimSpace(msg.Text)
+			if text == "" {
+				return transport.Response{Text: "Usage: /" + name + " <query>, see jojo help", Ephemeral: true}, nil
+			}
+			r, err := h.respond(ctx, msg, []string{text})
+			if err != nil {
+				return r, fmt.Errorf("%s: %v", h.Name, err)
+			}
+			return r, nil
+		}
+	}
+	return transport.Response{Text: "I don't know /" + msg.Command + " here", Ephemeral: true}, nil
+}
+
+// match runs h.Match, treating a panic as no match since it happens
+// outside the middleware that would recover it
+func match(h running, text string, logger *slog.Logger) (matches []string) {
+	defer func() {
+		if r := recover(); r != nil {
+			logger.Error("handler panicked in Match", "handler", h.Name, "panic", r)
+			matches = nil
+		}
+	}()
+	return h.Match(text)
+}
+
+// Reload asks every handler that can to re-read its configuration. All of
+// them are tried, the first error is returned
+func Reload() error {
+	mu.RLock()
+	hs := active
+	mu.RUnlock()
+
+	var first error
+	for _, h := range hs {
+		r, ok := h.Handler.(Reloader)
+		if !ok {
+			continue
+		}
+		if err := r.Reload(); err != nil && first == nil {
+			first = fmt.Errorf("%s: %v", h.Name, err)
+		}
+	}
+	return first
+}
+
+// SetAlert has Alert pass problems on to f, nil stops passing them on
+func SetAlert(f func(text string)) {
+	alertMu.Lock()
+	defer alertMu.Unlock()
+	alertTo = f
+}
+
+// Alert passes on a problem a handler ran into by itself rather than
+// while answering a message, like a file it watches that changed but
+// didn't load, so somebody hears about it besides the log
+func Alert(text string) {
+	alertMu.Lock()
+	f := alertTo
+	alertMu.Unlock()
+	if f != nil {
+		f(text)
+	}
+}
+
+// Check asks every handler that can whether it's healthy, returning the
+// first problem found
+func Check(ctx context.Context) error {
+	mu.RLock()
+	hs := active
+	mu.RUnlock()
+
+	for _, h := range hs {
+		c, ok := h.Handler.(Checker)
+		if !ok {
+			continue
+		}
+		if err := c.Check(ctx); err != nil {
+			return fmt.Errorf("%s: %v", h.Name, err)
+		}
+	}
+	return nil
+}
+
+// Close closes every handler built by Init
+func Close() error {
+	mu.Lock()
+	old := active
+	active = nil
+	mu.Unlock()
+	return closeAll(old)
+}
+
+func closeAll(hs []running) error {
+	var first error
+	for _, h := range hs {
+		if err := h.Close(); err != nil && first == nil {
+			first = fmt.Errorf("%s: %v", h.Name, err)
+		}
+	}
+	return first
+}
+
+// enabledAnywhere reports whether name could run in any channel, so
+// handlers nobody uses don't get built at all
+func enabledAnywhere(h config.Handlers, name string) bool {
+	if h.EnabledIn(name, "") {
+		return true
+	}
+	for channel := range h.Channels {
+		if h.EnabledIn(name, channel) {
+			return true
+		}
+	}
+	return false
+}
+
+func sortInfos(infos []Info) {
+	sort.Slice(infos, func(i, j int) bool {
+		if infos[i].Priority != infos[j].Priority {
+			return infos[i].Priority > infos[j].Priority
+		}
+		return infos[i].Name < infos[j].Name
+	})
+}
@@ -0,0 +1,159 @@
+package handler
+
+import (
+	"context"
+	"log/slog"
+	"strings"
+	"testing"
+
+	"github.com/komon/gosukebot/config"
+	"github.com/komon/gosukebot/logging"
+	"github.com/komon/gosukebot/transport"
+)
+
+// word responds with its name to any message containing it
+type word struct {
+	name   string
+	closed *bool
+}
+
+func (w word) Match(msg string) []string {
+	if strings.Contains(msg, w.name) {
+		return []string{w.name}
+	}
+	return nil
+}
+
+func (w word) Respond(ctx context.Context, msg transport.Message, matches []string) (transport.Response, error) {
+	return transport.Response{Text: w.name}, nil
+}
+
+func (w word) Close() error {
+	*w.closed = true
+	return nil
+}
+
+func registerWords(t *testing.T, closed map[string]*bool, names ...string) {
+	for i, name := range names {
+		name := name
+		closed[name] = new(bool)
+		Register(name, len(names)-i, "says "+name, func(config.Config, *slog.Logger) (Handler, error) {
+			return word{name, closed[name]}, nil
+		})
+	}
+	t.Cleanup(func() {
+		Close()
+		regMu.Lock()
+		for _, name := range names {
+			delete(registry, name)
+		}
+		regMu.Unlock()
+	})
+}
+
+func TestHandle(t *testing.T) {
+	closed := map[string]*bool{}
+	registerWords(t, closed, "ora", "muda", "unused")
+
+	cfg := config.Default()
+	cfg.Handlers.Enabled = []string{"ora", "muda"}
+	cfg.Handlers.Channels = map[string][]string{"CMUDA": {"muda"}}
+	if err := Init(cfg, logging.Discard()); err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		dispatch, channel, text, want string
+	}{
+		{DispatchAll, "C1", "ora muda", "ora\nmuda"},
+		{DispatchAll, "CMUDA", "ora muda", "muda"},
+		{DispatchAll, "C1", "nothing", ""},
+		{DispatchFirst, "C1", "ora muda", "ora"},
+		{DispatchFirst, "C1", "muda", "muda"},
+	}
+	for _, c := range cases {
+		handlers.Dispatch = c.dispatch
+		resp, err := Handle(context.Background(), transport.Message{Text: c.text, Channel: c.channel})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.Text != c.want {
+			t.Errorf("%s dispatch of %q in %s: expected %q, got %q", c.dispatch, c.text, c.channel, c.want, resp.Text)
+		}
+	}
+
+	handlers.Dispatch = DispatchAll
+	resp, _ := Handle(context.Background(), transport.Message{Text: "ora", Channel: "C1", Edited: true})
+	if resp.Text != "" {
+		t.Errorf("edits should only go to revisers, got %q", resp.Text)
+	}
+	if resp, _ := Handle(context.Background(), transport.Message{Text: "ora", Channel: "C1"}); resp.Revisable {
+		t.Error("expected a response from a handler that doesn't revise not to be revisable")
+	}
+
+	if len(active) != 2 {
+		t.Errorf("expected only the enabled handlers to be built, got %d", len(active))
+	}
+	Close()
+	if !*closed["ora"] || !*closed["muda"] {
+		t.Error("expected Close to close every handler")
+	}
+}
+
+func TestRegistered(t *testing.T) {
+	registerWords(t, map[string]*bool{}, "first", "second")
+	var names []string
+	for _, info := range Registered() {
+		if info.Name == "first" || info.Name == "second" || info.Name == "help" {
+			names = append(names, info.Name)
+		}
+	}
+	if strings.Join(names, ",") != "help,first,second" {
+		t.Errorf("expected handlers in priority order, got %v", names)
+	}
+}
+
+// sayer answers the say command with whatever it was given
+type sayer struct{ word }
+
+func (s sayer) Commands() []string {
+	return []string{"say"}
+}
+
+func (s sayer) Respond(ctx context.Context, msg transport.Message, matches []string) (transport.Response, error) {
+	return transport.Response{Text: strings.Join(matches, ",")}, nil
+}
+
+func TestHandleCommands(t *testing.T) {
+	closed := map[string]*bool{}
+	registerWords(t, closed, "say")
+	regMu.Lock()
+	registry["say"] = entry{registry["say"].Info, func(config.Config, *slog.Logger) (Handler, error) {
+		return sayer{word{"say", closed["say"]}}, nil
+	}}
+	regMu.Unlock()
+	cfg := config.Default()
+	cfg.Handlers.Channels = map[string][]string{"CQUIET": {}}
+	if err := Init(cfg, logging.Discard()); err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		command, channel, text, want string
+		ephemeral                    bool
+	}{
+		{"say", "C1", " ora ora ", "ora ora", false},
+		{"say", "C1", "", "Usage: /say <query>, see jojo help", true},
+		{"say", "CQUIET", "ora", "I don't know /say here", true},
+		{"shout", "C1", "ora", "I don't know /shout here", true},
+	}
+	for _, c := range cases {
+		resp, err := Handle(context.Background(), transport.Message{Command: c.command, Text: c.text, Channel: c.channel})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.Text != c.want || resp.Ephemeral != c.ephemeral {
+			t.Errorf("/%s %q in %s: expected %q, got %+v", c.command, c.text, c.channel, c.want, resp)
+		}
+	}
+}
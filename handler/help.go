@@ -0,0 +1,94 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"regexp"
+	"strings"
+
+	"github.com/komon/gosukebot/config"
+	"github.com/komon/gosukebot/transport"
+)
+
+func init() {
+	Register("help", 100, "this, try jojo help <topic> for more",
+		func(config.Config, *slog.Logger) (Handler, error) {
+			return helpHandler{}, nil
+		})
+}
+
+// Helper is implemented by handlers with more to say than their
+// description, `jojo help <topic>` asks the handler listing topic
+type Helper interface {
+	// Topics are the words Help answers to, the first one is the one
+	// `jojo help` suggests
+	Topics() []string
+	// Help returns the help text for one of Topics
+	Help(topic string) string
+}
+
+var helpCommand = regexp.MustCompile(`^jojo[\t ]+help(?:[\t ]+(\S+))?[\t ]*$`)
+
+// helpHandler answers `jojo help` from the registered handlers, so it
+// can't drift from what they actually do
+type helpHandler struct{}
+
+func (helpHandler) Match(msg string) []string {
+	m := helpCommand.FindStringSubmatch(strings.TrimSpace(msg))
+	if m == nil {
+		return nil
+	}
+	return []string{strings.ToLower(m[1])}
+}
+
+func (helpHandler) Respond(ctx context.Context, msg transport.Message, matches []string) (transport.Response, error) {
+	mu.RLock()
+	hs, cfg := active, handlers
+	mu.RUnlock()
+
+	var enabled []running
+	for _, h := range hs {
+		if cfg.EnabledIn(h.Name, msg.Channel) {
+			enabled = append(enabled, h)
+		}
+	}
+
+	topic := matches[0]
+	if topic == "" {
+		return transport.Response{Text: listHandlers(enabled)}, nil
+	}
+	for _, h := range enabled {
+		helper, ok := h.Handler.(Helper)
+		if !ok {
+			continue
+		}
+		for _, t := range helper.Topics() {
+			if strings.EqualFold(t, topic) {
+				return transport.Response{Text: helper.Help(t)}, nil
+			}
+		}
+	}
+	return transport.Response{
+		Text: fmt.Sprintf("I don't know anything about %s, try jojo help", topic),
+	}, nil
+}
+
+func (helpHandler) Close() error {
+	return nil
+}
+
+// listHandlers describes every handler in hs, pointing at their topics
+// if they have any
+func listHandlers(hs []running) string {
+	var b strings.Builder
+	b.WriteString("Here's what I can do:\n```")
+	for _, h := range hs {
+		fmt.Fprintf(&b, "\n%-11s %s", h.Name, h.Description)
+		if helper, ok := h.Handler.(Helper); ok && len(helper.Topics()) != 0 {
+			fmt.Fprintf(&b, " (jojo help %s)", helper.Topics()[0])
+		}
+	}
+	b.WriteString("```")
+	return b.String()
+}
@@ -0,0 +1,69 @@
+package handler
+
+import (
+	"context"
+	"log/slog"
+	"strings"
+	"testing"
+
+	"github.com/komon/gosukebot/config"
+	"github.com/komon/gosukebot/logging"
+	"github.com/komon/gosukebot/transport"
+)
+
+// topical is a word with help
+type topical struct {
+	word
+}
+
+func (topical) Topics() []string {
+	return []string{"stands"}
+}
+
+func (topical) Help(topic string) string {
+	return "star platinum, the world"
+}
+
+func TestHelp(t *testing.T) {
+	closed := map[string]*bool{"stand": new(bool), "muda": new(bool)}
+	Register("stand", 1, "summons a stand", func(config.Config, *slog.Logger) (Handler, error) {
+		return topical{word{"stand", closed["stand"]}}, nil
+	})
+	registerWords(t, closed, "muda")
+	t.Cleanup(func() {
+		regMu.Lock()
+		delete(registry, "stand")
+		regMu.Unlock()
+	})
+
+	cfg := config.Default()
+	cfg.Handlers.Enabled = []string{"help", "stand", "muda"}
+	cfg.Handlers.Channels = map[string][]string{"CMUDA": {"help", "muda"}}
+	if err := Init(cfg, logging.Discard()); err != nil {
+		t.Fatal(err)
+	}
+
+	help := func(text, channel string) string {
+		resp, err := Handle(context.Background(), transport.Message{Text: text, Channel: channel})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp.Text
+	}
+
+	list := help("jojo help", "C1")
+	for _, want := range []string{"summons a stand (jojo help stands)", "says muda", "help "} {
+		if !strings.Contains(list, want) {
+			t.Errorf("expected %q in help, got %q", want, list)
+		}
+	}
+	if list := help("jojo help", "CMUDA"); strings.Contains(list, "stand") {
+		t.Errorf("help listed a handler that isn't enabled in the channel: %q", list)
+	}
+	if got := help("jojo help Stands", "C1"); got != "star platinum, the world" {
+		t.Errorf("unexpected topic help %q", got)
+	}
+	if got := help("jojo help hamon", "C1"); !strings.Contains(got, "don't know") {
+		t.Errorf("unexpected unknown topic help %q", got)
+	}
+}
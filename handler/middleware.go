@@ -0,0 +1,150 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"runtime/debug"
+	"time"
+	"unicode/utf8"
+
+	"github.com/komon/gosukebot/config"
+	"github.com/komon/gosukebot/metrics"
+	"github.com/komon/gosukebot/transport"
+)
+
+var (
+	matchCount = metrics.NewCounter("gosukebot_handler_matches_total",
+		"Messages each handler found something to respond to in.", "handler")
+	respondErrors = metrics.NewCounter("gosukebot_handler_errors_total",
+		"Responses each handler failed to come up with.", "handler")
+	respondTime = metrics.NewHistogram("gosukebot_handler_seconds",
+		"How long each handler took to respond.", metrics.Buckets, "handler")
+)
+
+// RespondFunc is the shape of Handler.Respond
+type RespondFunc func(ctx context.Context, msg transport.Message, matches []string) (transport.Response, error)
+
+// Middleware wraps a handler's Respond with something every handler
+// should get, like rate limiting. It's called once per handler when the
+// handlers are built, with the Info of the handler it's wrapping
+type Middleware func(info Info, next RespondFunc) RespondFunc
+
+// chain wraps respond in mws, the first middleware is the outermost
+func chain(info Info, respond RespondFunc, mws []Middleware) RespondFunc {
+	for i := len(mws) - 1; i >= 0; i-- {
+		respond = mws[i](info, respond)
+	}
+	return respond
+}
+
+// middleware returns the chain Init wraps every handler in. Measure goes
+// inside Allow so matches from channels and users that aren't allowed
+// aren't counted
+func middleware(cfg config.Config, logger *slog.Logger) []Middleware {
+	mws := []Middleware{Recover(logger)}
+	if len(cfg.Limits.AllowChannels) != 0 || len(cfg.Limits.AllowUsers) != 0 {
+		mws = append(mws, Allow(cfg.Limits.AllowChannels, cfg.Limits.AllowUsers, logger))
+	}
+	mws = append(mws, Measure(), RateLimit(cfg.Limits, logger))
+	if cfg.Limits.MaxLength > 0 {
+		mws = append(mws, Truncate(cfg.Limits.MaxLength))
+	}
+	return mws
+}
+
+// Recover turns a panicking handler into an error instead of letting it
+// take the whole bot down
+func Recover(logger *slog.Logger) Middleware {
+	return func(info Info, next RespondFunc) RespondFunc {
+		return func(ctx context.Context, msg transport.Message, matches []string) (resp transport.Response, err error) {
+			defer func() {
+				if r := recover(); r != nil {
+					logger.Error("handler panicked", "handler", info.Name, "panic", r,
+						"stack", string(debug.Stack()))
+					resp, err = transport.Response{}, fmt.Errorf("something went wrong")
+				}
+			}()
+			return next(ctx, msg, matches)
+		}
+	}
+}
+
+// Allow only lets the listed channels and users get responses, an empty
+// list lets everyone through
+func Allow(channels, users []string, logger *slog.Logger) Middleware {
+	allowedChannels, allowedUsers := set(channels), set(users)
+	return func(info Info, next RespondFunc) RespondFunc {
+		return func(ctx context.Context, msg transport.Message, matches []string) (transport.Response, error) {
+			if len(allowedChannels) != 0 && !allowedChannels[msg.Channel] ||
+				len(allowedUsers) != 0 && !allowedUsers[msg.User] {
+				logger.Debug("not allowed", "handler", info.Name, "channel", msg.Channel, "user", msg.User)
+				return transport.Response{}, nil
+			}
+			return next(ctx, msg, matches)
+		}
+	}
+}
+
+// Truncate cuts responses off after max bytes. The text of every card is
+// cut off too, and Notes, since transports show those instead of Text
+func Truncate(max int) Middleware {
+	return func(info Info, next RespondFunc) RespondFunc {
+		return func(ctx context.Context, msg transport.Message, matches []string) (transport.Response, error) {
+			resp, err := next(ctx, msg, matches)
+			resp.Text = truncate(resp.Text, max)
+			resp.Notes = truncate(resp.Notes, max)
+			if len(resp.Cards) != 0 {
+				cards := make([]transport.Card, len(resp.Cards))
+				for i, c := range resp.Cards {
+					c.Text = truncate(c.Text, max)
+					cards[i] = c
+				}
+				resp.Cards = cards
+			}
+			return resp, err
+		}
+	}
+}
+
+// truncate shortens s to at most max bytes, ending in an ellipsis,
+// without splitting a rune
+func truncate(s string, max int) string {
+	const ellipsis = "…"
+	if len(s) <= max {
+		return s
+	}
+	cut := max - len(ellipsis)
+	if cut < 0 {
+		cut = 0
+	}
+	for cut > 0 && !utf8.RuneStart(s[cut]) {
+		cut--
+	}
+	return s[:cut] + ellipsis
+}
+
+// Measure counts every match and error, and how long each response took,
+// in the metrics served on /metrics
+func Measure() Middleware {
+	return func(info Info, next RespondFunc) RespondFunc {
+		return func(ctx context.Context, msg transport.Message, matches []string) (resp transport.Response, err error) {
+			matchCount.Inc(info.Name)
+			defer func(start time.Time) {
+				respondTime.Since(start, info.Name)
+				if err != nil {
+					respondErrors.Inc(info.Name)
+				}
+			}(time.Now())
+			return next(ctx, msg, matches)
+		}
+	}
+}
+
+func set(list []string) map[string]bool {
+	s := make(map[string]bool, len(list))
+	for _, v := range list {
+		s[v] = true
+	}
+	return s
+}
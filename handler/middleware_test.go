@@ -0,0 +1,99 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/komon/gosukebot/config"
+	"github.com/komon/gosukebot/logging"
+	"github.com/komon/gosukebot/transport"
+)
+
+func respondWith(text string) RespondFunc {
+	return func(ctx context.Context, msg transport.Message, matches []string) (transport.Response, error) {
+		return transport.Response{Text: text}, nil
+	}
+}
+
+func TestRecover(t *testing.T) {
+	panics := func(ctx context.Context, msg transport.Message, matches []string) (transport.Response, error) {
+		panic("za warudo")
+	}
+	respond := chain(Info{Name: "dio"}, panics, []Middleware{Recover(logging.Discard())})
+	if _, err := respond(context.Background(), transport.Message{}, nil); err == nil {
+		t.Error("expected the panic to come back as an error")
+	}
+}
+
+func TestAllow(t *testing.T) {
+	respond := chain(Info{Name: "ora"}, respondWith("ora"),
+		[]Middleware{Allow([]string{"C1"}, nil, logging.Discard())})
+	if resp, _ := respond(context.Background(), transport.Message{Channel: "C2"}, nil); resp.Text != "" {
+		t.Errorf("expected nothing in C2, got %q", resp.Text)
+	}
+	if resp, _ := respond(context.Background(), transport.Message{Channel: "C1"}, nil); resp.Text != "ora" {
+		t.Errorf("expected ora in C1, got %q", resp.Text)
+	}
+}
+
+func TestTruncate(t *testing.T) {
+	cases := []struct {
+		in   string
+		max  int
+		want string
+	}{
+		{"short", 10, "short"},
+		{"オラオラオラ", 10, "オラ…"},
+		{"muda muda muda", 7, "muda…"},
+	}
+	for _, c := range cases {
+		if got := truncate(c.in, c.max); got != c.want {
+			t.Errorf("truncate(%q, %d): expected %q, got %q", c.in, c.max, c.want, got)
+		}
+	}
+}
+
+func TestTruncateCards(t *testing.T) {
+	long := func(ctx context.Context, msg transport.Message, matches []string) (transport.Response, error) {
+		return transport.Response{
+			Text:  "muda muda muda",
+			Notes: "muda muda muda",
+			Cards: []transport.Card{{Name: "The World", Text: "muda muda muda"}},
+		}, nil
+	}
+	respond := chain(Info{Name: "dio"}, long, []Middleware{Truncate(7)})
+	resp, _ := respond(context.Background(), transport.Message{}, nil)
+	if resp.Text != "muda…" || resp.Notes != "muda…" || resp.Cards[0].Text != "muda…" {
+		t.Errorf("expected text, notes and cards to be cut off, got %+v", resp)
+	}
+}
+
+func TestMeasureSkipsDisallowed(t *testing.T) {
+	cfg := config.Default()
+	cfg.Limits.AllowChannels = []string{"C1"}
+	respond := chain(Info{Name: "allowed"}, respondWith("ora"), middleware(cfg, logging.Discard()))
+	before := matchCount.Value("allowed")
+	respond(context.Background(), transport.Message{Channel: "C2"}, nil)
+	respond(context.Background(), transport.Message{Channel: "C1"}, nil)
+	if got := matchCount.Value("allowed") - before; got != 1 {
+		t.Errorf("expected only the allowed match counted, got %v", got)
+	}
+}
+
+func TestMeasure(t *testing.T) {
+	fails := func(ctx context.Context, msg transport.Message, matches []string) (transport.Response, error) {
+		return transport.Response{}, errors.New("muda")
+	}
+	respond := chain(Info{Name: "measured"}, respondWith("ora"), []Middleware{Measure()})
+	fail := chain(Info{Name: "measured"}, fails, []Middleware{Measure()})
+	count, errs := respondTime.Count("measured"), respondErrors.Value("measured")
+	respond(context.Background(), transport.Message{}, nil)
+	fail(context.Background(), transport.Message{}, nil)
+	if got := respondTime.Count("measured") - count; got != 2 {
+		t.Errorf("expected 2 responses timed, got %d", got)
+	}
+	if got := respondErrors.Value("measured") - errs; got != 1 {
+		t.Errorf("expected 1 error counted, got %v", got)
+	}
+}
@@ -1,47 +1,128 @@
 package mtgsearch
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
-	"log"
+	"log/slog"
 	"strings"
+	"time"
 	"unicode"
 
 	sq "github.com/Masterminds/squirrel"
+	"github.com/komon/gosukebot/config"
+	"github.com/komon/gosukebot/handler"
+	"github.com/komon/gosukebot/metrics"
+	"github.com/komon/gosukebot/transport"
 	_ "github.com/mattn/go-sqlite3"
 )
 
-var db *sql.DB
-var matches []string
+var (
+	queryTime = metrics.NewHistogram("gosukebot_mtgsearch_query_seconds",
+		"How long card searches take in sqlite.", metrics.Buckets)
+	notFound = metrics.NewCounter("gosukebot_cards_not_found_total",
+		"Card searches that found nothing.")
+)
+
+func init() {
+	handler.Register("mtgsearch", 10, "card lookups like [[Lightning Bolt]] or [[Lightning Bolt|M10]]",
+		func(cfg config.Config, logger *slog.Logger) (handler.Handler, error) {
+			return New(cfg.Database, logger)
+		})
+}
 
 type mtgSearchResult struct {
-	name string
-	cost string
-	text string
-	id   int
-	set  string
+	cardID    string
+	name      string
+	cost      string
+	text      string
+	id        int
+	set       string
+	typ       string
+	power     string
+	toughness string
+	loyalty   string
+	// candidates are the distinct names matched when none of them was
+	// an exact match, only set if there's more than one
+	candidates []string
+}
+
+// the most names offered when a search is ambiguous
+const maxCandidates = 5
+
+// resultColumns are the columns scanResult expects, in order
+var resultColumns = []string{
+	"cards.id", "cards.name", "mana_cost", "card_text", "cards.multiverse_id",
+	"ifnull(cards.type, '') as type", "ifnull(power, '') as power",
+	"ifnull(toughness, '') as toughness", "ifnull(loyalty, '') as loyalty",
 }
 
 // MtgSearchHandler satisfies the handler.Handler interface
-type MtgSearchHandler struct{}
+type MtgSearchHandler struct {
+	logger *slog.Logger
+	db     *sql.DB
+}
 
-// Returns a new MtgSearchHandler and initializes the package-level
-// db connection
-func New() MtgSearchHandler {
-	var err error
-	db, err = sql.Open("sqlite3", "mtg.db")
+// Returns a new MtgSearchHandler that logs to logger, with its own
+// connection to the card database at dbPath
+func New(dbPath string, logger *slog.Logger) (MtgSearchHandler, error) {
+	db, err := sql.Open("sqlite3", dbPath)
 	if err != nil {
-		log.Fatal(err)
+		return MtgSearchHandler{}, err
+	}
+
+	return MtgSearchHandler{logger: logger, db: db}, nil
+}
+
+// Revise satisfies the handler.Reviser interface, lookups are redone when
+// the message asking for them is edited
+func (msh MtgSearchHandler) Revise() bool {
+	return true
+}
+
+// Check satisfies the handler.Checker interface by making sure the card
+// database can still be read. A ping isn't enough, sqlite would happily
+// open an empty database where the real one used to be
+func (msh MtgSearchHandler) Check(ctx context.Context) error {
+	var one int
+	err := msh.db.QueryRowContext(ctx, "select 1 from cards limit 1").Scan(&one)
+	if err == sql.ErrNoRows {
+		return nil
 	}
+	return err
+}
 
-	return MtgSearchHandler{}
+// Close closes the handler's db connection
+func (msh MtgSearchHandler) Close() error {
+	return msh.db.Close()
+}
+
+// Topics satisfies the handler.Helper interface
+func (msh MtgSearchHandler) Topics() []string {
+	return []string{"search", "mtgsearch", "card"}
+}
+
+// Commands satisfies the handler.Commander interface, /card Lightning
+// Bolt looks up the same card as [[Lightning Bolt]]
+func (msh MtgSearchHandler) Commands() []string {
+	return []string{"card"}
+}
+
+// Help explains card searches
+func (msh MtgSearchHandler) Help(topic string) string {
+	return "```[[card name]] anywhere in a message looks up a card, as long as the brackets start the message or come after a space\n\n" +
+		"  [[Lightning Bolt]]       the card's image, cost and text\n" +
+		"  [[Lightning Bolt|M10]]   the printing from a set\n" +
+		"  [[Lightning Bolt|all]]   every set it was printed in\n" +
+		"  [[bolt]] [[shock]]       several cards at once, names don't have to be exact\n\n" +
+		"On Slack, /card Lightning Bolt shows only you the card until you post it to the channel```"
 }
 
 // Match searches a string for substrings [[inside double square brackets]]
-// Returns true if any such substrings are found,  and stores the strings
-// minus the brackets for the Response method
-func (msh MtgSearchHandler) Match(msg string) bool {
-	matches = []string{}
+// Returns the strings minus the brackets for the Respond method, or nil
+// if there aren't any
+func (msh MtgSearchHandler) Match(msg string) []string {
+	var matches []string
 	// search for instances of opening brackets
 	for i := strings.Index(msg, "[["); i != -1; i = strings.Index(msg, "[[") {
 		// if there's a closing square bracket pair to match
@@ -60,14 +141,16 @@ func (msh MtgSearchHandler) Match(msg string) bool {
 			msg = msg[i+2:]
 		}
 	}
-	return len(matches) > 0
+	return matches
 }
 
-// Respond returns a string containing info about the card searched
-// for, if no card is found it returns a message saying so
-func (msh MtgSearchHandler) Respond() (string, error) {
+// Respond returns a response containing info about the cards searched
+// for in matches, both as text and as structured cards for transports
+// that can render them. If no card is found it says so
+func (msh MtgSearchHandler) Respond(ctx context.Context, msg transport.Message, matches []string) (transport.Response, error) {
 	multi := len(matches) > 1
 	response := ""
+	cards := []transport.Card{}
 
 	for _, match := range matches {
 		var (
@@ -76,17 +159,42 @@ func (msh MtgSearchHandler) Respond() (string, error) {
 		)
 		args := strings.Split(match, "|")
 
+		start := time.Now()
 		if len(args) == 1 {
-			res, err = runSearch(args[0], "")
+			res, err = msh.runSearch(ctx, args[0], "")
 		} else {
-			res, err = runSearch(args[0], args[1])
+			res, err = msh.runSearch(ctx, args[0], args[1])
+		}
+		queryTime.Since(start)
+		logger := msh.logger.With("query", match, "channel", msg.Channel,
+			"user", msg.User, "latency", time.Since(start))
+		if ctx.Err() != nil {
+			return transport.Response{Text: response, Cards: cards}, ctx.Err()
 		}
 
+		if len(res.candidates) > 1 {
+			logger.Info("ambiguous card name", "candidates", len(res.candidates))
+			response += "Did you mean [[" + strings.Join(res.candidates, "]], [[") + "]]?\n"
+			cards = append(cards, transport.Card{Query: match, Candidates: res.candidates})
+			continue
+		}
 		if err != nil || res.name == "" {
 			response += "Card Not Found!\n"
-			log.Println(err)
+			cards = append(cards, transport.Card{Query: match})
+			if err != nil {
+				logger.Error("card search failed", "err", err)
+			} else {
+				logger.Info("card not found")
+				notFound.Inc()
+			}
 			continue
 		}
+		logger.Debug("card found", "name", res.name)
+		card, err := msh.toCard(ctx, match, res)
+		if err != nil {
+			logger.Warn("printing lookup failed", "err", err)
+		}
+		cards = append(cards, card)
 		if res.text == "" {
 			res.text = " "
 		}
@@ -98,19 +206,18 @@ func (msh MtgSearchHandler) Respond() (string, error) {
 				res.cost, res.text, res.set)
 		}
 	}
-	return response, nil
+	return transport.Response{Text: response, Cards: cards}, nil
 }
 
-func runSearch(name string, set string) (mtgSearchResult, error) {
+func (msh MtgSearchHandler) runSearch(ctx context.Context, name string, set string) (mtgSearchResult, error) {
 	var (
 		rows *sql.Rows
 		err  error
-		id   []uint8
 	)
 
 	res := mtgSearchResult{}
 	nameQuery := sq.
-		Select("cards.id", "cards.name", "mana_cost", "card_text", "cards.multiverse_id").
+		Select(resultColumns...).
 		From("cards").
 		Join("virt_cards on cards.id=virt_cards.id").
 		Where("virt_cards.name match ? and cards.multiverse_id != 0", name)
@@ -122,23 +229,24 @@ func runSearch(name string, set string) (mtgSearchResult, error) {
 				Options("distinct").
 				FromSelect(nameQuery, "n").
 				Join("set_card on n.id=set_card.id"), "n")
-		err = query.RunWith(db).QueryRow().Scan(&res.set)
+		err = query.RunWith(msh.db).QueryRowContext(ctx).Scan(&res.set)
 		if err != nil {
 			return res, err
 		}
 	}
 	if set != "" && !strings.EqualFold(set, "ALL") {
 		query := sq.
-			Select("n.id", "name", "mana_cost", "card_text", "multiverse_id").
+			Select("n.id", "name", "mana_cost", "card_text", "multiverse_id",
+				"type", "power", "toughness", "loyalty").
 			FromSelect(nameQuery, "n").
 			Join("set_card on n.id=set_card.id").
 			Where(sq.Eq{"set_code": strings.ToUpper(set)})
-		rows, err = query.RunWith(db).Query()
+		rows, err = query.RunWith(msh.db).QueryContext(ctx)
 	} else {
-		rows, err = nameQuery.RunWith(db).Query()
+		rows, err = nameQuery.RunWith(msh.db).QueryContext(ctx)
 	}
 	if err != nil {
-		log.Fatalf("Error in mtgsearch with query: %s, %s, %v\n", name, set, err)
+		return res, fmt.Errorf("error in mtgsearch with query: %s, %s, %v", name, set, err)
 	}
 	defer rows.Close()
 
@@ -146,21 +254,31 @@ func runSearch(name string, set string) (mtgSearchResult, error) {
 		return res, err
 	}
 
-	err = rows.Scan(&id, &res.name, &res.cost, &res.text, &res.id)
+	allSets := res.set
+	res, err = scanResult(rows)
 	if err != nil {
 		return mtgSearchResult{}, err
 	}
+	res.set = allSets
 
+	// the full text search matches names containing the words in any
+	// order, so unless one of them is exact the user has to pick
 	if !strings.EqualFold(name, res.name) {
+		names := []string{res.name}
 		for rows.Next() {
-			res := mtgSearchResult{}
-			err := rows.Scan(&id, &res.name, &res.cost, &res.text, &res.id)
+			res, err := scanResult(rows)
 			if err != nil {
-				log.Fatal(err)
+				return res, err
 			}
 			if strings.EqualFold(res.name, name) {
 				return res, err
 			}
+			if len(names) < maxCandidates && !contains(names, res.name) {
+				names = append(names, res.name)
+			}
+		}
+		if len(names) > 1 {
+			res.candidates = names
 		}
 	}
 	if res.set == "" {
@@ -169,6 +287,55 @@ func runSearch(name string, set string) (mtgSearchResult, error) {
 	return res, err
 }
 
+func contains(names []string, name string) bool {
+	for _, n := range names {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
+
+// scanResult reads a row selected with resultColumns
+func scanResult(rows *sql.Rows) (mtgSearchResult, error) {
+	res := mtgSearchResult{}
+	err := rows.Scan(&res.cardID, &res.name, &res.cost, &res.text, &res.id,
+		&res.typ, &res.power, &res.toughness, &res.loyalty)
+	return res, err
+}
+
+// toCard fills out a structured card from a search result, looking up the
+// set and rarity of the printing that was found
+func (msh MtgSearchHandler) toCard(ctx context.Context, query string, res mtgSearchResult) (transport.Card, error) {
+	card := transport.Card{
+		Query:     query,
+		Name:      res.name,
+		Cost:      res.cost,
+		Type:      res.typ,
+		Text:      res.text,
+		Power:     res.power,
+		Toughness: res.toughness,
+		Loyalty:   res.loyalty,
+		Set:       res.set,
+		ImageURL:  formatImageURL(res.id),
+	}
+	var set string
+	err := sq.
+		Select("set_code", "ifnull(rarity, '')").
+		From("set_card").
+		LeftJoin("card_rarity on set_card.id=card_rarity.id").
+		Where(sq.Eq{"set_card.id": res.cardID}).
+		Limit(1).
+		RunWith(msh.db).QueryRowContext(ctx).Scan(&set, &card.Rarity)
+	if card.Set == "" {
+		card.Set = set
+	}
+	if err == sql.ErrNoRows {
+		err = nil
+	}
+	return card, err
+}
+
 func formatImageURL(multiverseID int) string {
 	return fmt.Sprintf("http://gatherer.wizards.com/Handlers/Image.ashx?multiverseid=%d&type=card", multiverseID)
 }
@@ -0,0 +1,92 @@
+package mtgstats
+
+import (
+	"fmt"
+	"strings"
+)
+
+// filter documents a key joinAndWhere understands, keys[0] is the one
+// shown first
+type filter struct {
+	keys    []string
+	values  string
+	example string
+}
+
+var filters = []filter{
+	{[]string{"name", "names"}, "exact card names", "name: !Gleemax"},
+	{[]string{"color", "colors"}, "exact colors in wubrg, 0 for colorless", "color: br|0"},
+	{[]string{"colorID", "colorIDs"}, "color identity, like color", "colorID: wu"},
+	{[]string{"type", "types"}, "creature, artifact, enchantment, land, planeswalker, instant, sorcery, tribal", "type: creature|!artifact"},
+	{[]string{"supertype", "supertypes"}, "legendary, basic, ongoing, snow, world", "supertype: legendary"},
+	{[]string{"subtype", "subtypes"}, "anything in the type line", "subtype: goblin|!warrior"},
+	{[]string{"set", "sets", "set_code", "set_codes"}, "set codes", "set: M10|M11"},
+	{[]string{"rarity", "rarities", "rareness"}, "common, uncommon, rare, mythic, special, or c, u, r, mr, s", "rarity: mr"},
+}
+
+// verb documents something runSearch can work out about the cards
+type verb struct {
+	name string
+	does string
+}
+
+var verbs = []verb{
+	{"count", "how many cards match, the default is count: id"},
+	{"avg", "the average of a column"},
+	{"sum", "the total of a column"},
+	{"min", "the card with the lowest value in a column"},
+	{"max", "the card with the highest value in a column"},
+}
+
+// isVerb reports whether k is one of verbs rather than a filter
+func isVerb(k string) bool {
+	for _, v := range verbs {
+		if v.name == k {
+			return true
+		}
+	}
+	return false
+}
+
+// Topics satisfies the handler.Helper interface
+func (msh MtgStatsHandler) Topics() []string {
+	return []string{"stats", "mtgstats", "verbs"}
+}
+
+// Commands satisfies the handler.Commander interface, /mtgstats color: r
+// works the same as #[[color: r]]
+func (msh MtgStatsHandler) Commands() []string {
+	return []string{"mtgstats"}
+}
+
+// Help explains stats queries, or just the verbs if that's what's asked
+// for
+func (msh MtgStatsHandler) Help(topic string) string {
+	if topic == "verbs" {
+		return "```" + verbHelp() + "```"
+	}
+
+	var b strings.Builder
+	b.WriteString("```#[[key: value, key: value, ...]] works something out about every card matching the filters\n")
+	b.WriteString("separate values with | to match any of them, put ! in front of a value to leave it out\n\n")
+	b.WriteString("filters:\n")
+	for _, f := range filters {
+		fmt.Fprintf(&b, "  %-10s %s, like %s\n", f.keys[0], f.values, f.example)
+	}
+	b.WriteString("\n" + verbHelp() + "\n")
+	b.WriteString("examples:\n")
+	b.WriteString("  #[[color: r, type: creature]]\n")
+	b.WriteString("  #[[set: M10, rarity: mr, avg: cmc]]\n")
+	b.WriteString("  #[[type: planeswalker, max: loyalty]]\n\n")
+	b.WriteString("On Slack, /mtgstats color: r, type: creature shows only you the answer until you post it to the channel```")
+	return b.String()
+}
+
+func verbHelp() string {
+	var b strings.Builder
+	b.WriteString("verbs, with a column like cmc, power, toughness or loyalty:\n")
+	for _, v := range verbs {
+		fmt.Fprintf(&b, "  %-10s %s\n", v.name, v.does)
+	}
+	return strings.TrimSuffix(b.String(), "\n")
+}
@@ -1,45 +1,85 @@
 package mtgstats
 
 import (
+	"context"
 	"database/sql"
-	"log"
+	"log/slog"
 	"strings"
+	"time"
 
 	sq "github.com/Masterminds/squirrel"
+	"github.com/komon/gosukebot/config"
+	"github.com/komon/gosukebot/handler"
+	"github.com/komon/gosukebot/metrics"
+	"github.com/komon/gosukebot/transport"
 	_ "github.com/mattn/go-sqlite3"
 )
 
-var db *sql.DB
-var matches []string
+var queryTime = metrics.NewHistogram("gosukebot_mtgstats_query_seconds",
+	"How long stats queries take in sqlite.", metrics.Buckets)
 
-type MtgStatsHandler struct{}
+func init() {
+	handler.Register("mtgstats", 20, "card statistics like #[[color:R, type:creature, avg:cmc]]",
+		func(cfg config.Config, logger *slog.Logger) (handler.Handler, error) {
+			return New(cfg.Database, logger)
+		})
+}
+
+// MtgStatsHandler satisfies the handler.Handler interface
+type MtgStatsHandler struct {
+	logger *slog.Logger
+	db     *sql.DB
+}
 
 type Query map[string][]string
 
-func New() MtgStatsHandler {
-	var err error
-	db, err = sql.Open("sqlite3", "mtg.db")
+// New returns a new MtgStatsHandler that logs to logger, with its own
+// connection to the card database at dbPath
+func New(dbPath string, logger *slog.Logger) (MtgStatsHandler, error) {
+	db, err := sql.Open("sqlite3", dbPath)
 	if err != nil {
-		log.Fatal(err)
+		return MtgStatsHandler{}, err
 	}
 
-	return MtgStatsHandler{}
+	return MtgStatsHandler{logger: logger, db: db}, nil
 }
 
-func (msh MtgStatsHandler) Match(msg string) bool {
-	matches = []string{}
+// Revise satisfies the handler.Reviser interface, lookups are redone when
+// the message asking for them is edited
+func (msh MtgStatsHandler) Revise() bool {
+	return true
+}
+
+// Check satisfies the handler.Checker interface by making sure the card
+// database can still be read
+func (msh MtgStatsHandler) Check(ctx context.Context) error {
+	var one int
+	err := msh.db.QueryRowContext(ctx, "select 1 from cards limit 1").Scan(&one)
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	return err
+}
+
+// Close closes the handler's db connection
+func (msh MtgStatsHandler) Close() error {
+	return msh.db.Close()
+}
+
+func (msh MtgStatsHandler) Match(msg string) []string {
+	var matches []string
 	for i := strings.Index(msg, "#[["); i != -1; i = strings.Index(msg, "#[[") {
 		if j := strings.Index(msg[i+3:], "]]"); j != -1 {
-			matches = append(matches, msg[i+3:j+3])
-			msg = msg[j+2:]
+			matches = append(matches, msg[i+3:i+3+j])
+			msg = msg[i+3+j+2:]
 		} else {
 			msg = msg[i+3:]
 		}
 	}
-	return len(matches) > 0
+	return matches
 }
 
-func (msh MtgStatsHandler) Respond() (string, error) {
+func (msh MtgStatsHandler) Respond(ctx context.Context, msg transport.Message, matches []string) (transport.Response, error) {
 	response := ""
 	for _, match := range matches {
 		query := Query{}
@@ -50,9 +90,12 @@ func (msh MtgStatsHandler) Respond() (string, error) {
 				break
 			}
 			kv := strings.Split(arg, ":")
+			if len(kv) < 2 {
+				msh.logger.Info("skipping malformed stats term", "term", arg)
+				continue
+			}
 			k, v := strings.TrimSpace(kv[0]), strMap(strings.Split(kv[1], "|"), strings.TrimSpace)
-			if k == "avg" || k == "count" || k == "sum" ||
-				k == "min" || k == "max" {
+			if isVerb(k) {
 				verbs[k] = v
 			} else {
 				query[k] = v
@@ -61,14 +104,21 @@ func (msh MtgStatsHandler) Respond() (string, error) {
 		if len(verbs) == 0 {
 			verbs["count"] = []string{"id"}
 		}
-		response += runSearch(query, verbs)
+		start := time.Now()
+		response += runSearch(ctx, msh.db, query, verbs)
+		queryTime.Since(start)
+		msh.logger.Debug("stats query", "query", match, "channel", msg.Channel,
+			"user", msg.User, "latency", time.Since(start))
+		if ctx.Err() != nil {
+			return transport.Response{Text: response}, ctx.Err()
+		}
 	}
 
-	return response, nil
+	return transport.Response{Text: response}, nil
 }
 
 //check statsutils.go for the definitions of unfamiliar functions used here
-func runSearch(query Query, verbs Query) string {
+func runSearch(ctx context.Context, db *sql.DB, query Query, verbs Query) string {
 	response := ""
 	search := joinAndWhere(sq.Select("*").From("cards").Suffix("collate nocase"), query)
 
@@ -76,15 +126,15 @@ func runSearch(query Query, verbs Query) string {
 		for _, arg := range args {
 			switch verb {
 			case "avg":
-				response += avg(search, arg)
+				response += avg(ctx, db, search, arg)
 			case "count":
-				response += count(search, arg)
+				response += count(ctx, db, search, arg)
 			case "sum":
-				response += sum(search, arg)
+				response += sum(ctx, db, search, arg)
 			case "min":
-				response += min(search, arg)
+				response += min(ctx, db, search, arg)
 			case "max":
-				response += max(search, arg)
+				response += max(ctx, db, search, arg)
 			default:
 				continue
 			}
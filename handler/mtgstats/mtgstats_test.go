@@ -1,10 +1,18 @@
 package mtgstats
 
 import (
+	"context"
+	"database/sql"
 	"fmt"
+	"path/filepath"
+	"strings"
 	"testing"
 
 	sq "github.com/Masterminds/squirrel"
+	"github.com/komon/gosukebot/config"
+	"github.com/komon/gosukebot/handler"
+	"github.com/komon/gosukebot/logging"
+	"github.com/komon/gosukebot/transport"
 )
 
 func TestJoinAndWhere(t *testing.T) {
@@ -20,3 +28,68 @@ func TestJoinAndWhere(t *testing.T) {
 func TestSplitNegatives(t *testing.T) {
 	fmt.Println(splitNegatives([]string{"!Gleemax"}))
 }
+
+func TestMatch(t *testing.T) {
+	matches := MtgStatsHandler{}.Match("how many? #[[color: r, type: creature]] and #[[avg: cmc]]")
+	if len(matches) != 2 || matches[0] != "color: r, type: creature" || matches[1] != "avg: cmc" {
+		t.Errorf("unexpected matches %q", matches)
+	}
+	if matches := (MtgStatsHandler{}).Match("[[Lightning Bolt]]"); matches != nil {
+		t.Errorf("expected no matches, got %q", matches)
+	}
+}
+
+func TestSplitNegativesEmpty(t *testing.T) {
+	eq, not := splitNegatives([]string{"r", "", "!u"})
+	if len(eq) != 1 || eq[0] != "r" || len(not) != 1 || not[0] != "u" {
+		t.Errorf("unexpected split %q %q", eq, not)
+	}
+}
+
+func TestHelpFiltersAreUnderstood(t *testing.T) {
+	base, _, _ := joinAndWhere(sq.Select("*").From("cards"), Query{}).ToSql()
+	for _, f := range filters {
+		for _, k := range f.keys {
+			v := strings.TrimSpace(strings.SplitN(f.example, ":", 2)[1])
+			sql, _, _ := joinAndWhere(sq.Select("*").From("cards"), Query{k: strings.Split(v, "|")}).ToSql()
+			if sql == base {
+				t.Errorf("joinAndWhere ignored %s: %s", k, v)
+			}
+		}
+	}
+	if help := (MtgStatsHandler{}).Help("stats"); !strings.Contains(help, "rarity") || !strings.Contains(help, "avg") {
+		t.Errorf("stats help is missing filters or verbs: %s", help)
+	}
+}
+
+func TestReinit(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "mtg.db")
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = db.Exec(`create table cards (id integer, multiverse_id integer, name text, cmc integer);
+		insert into cards values (1, 10, 'Lightning Bolt', 1), (2, 20, 'Counterspell', 2)`)
+	db.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := config.Default()
+	cfg.Database = path
+	cfg.Handlers.Enabled = []string{"mtgstats"}
+	t.Cleanup(func() { handler.Close() })
+	for i := 0; i < 2; i++ {
+		if err := handler.Init(cfg, logging.Discard()); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	resp, err := handler.Handle(context.Background(), transport.Message{Text: "#[[count: id]]", Channel: "C1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Text != "Count: 2" {
+		t.Errorf("expected the new handler's db to still be open, got %q", resp.Text)
+	}
+}
@@ -2,6 +2,8 @@ package mtgstats
 
 import (
 	"bytes"
+	"context"
+	"database/sql"
 	"fmt"
 	"strings"
 	"unicode"
@@ -139,7 +141,7 @@ func filterRarity(search sq.SelectBuilder, rs []string) sq.SelectBuilder {
 			view = "uncommons"
 		case "Rare", "Rares", "R":
 			view = "rares"
-		case "Mythic", "Mythics", "Mythic Rare", "Mythic Rares", "MR":
+		case "Mythic", "Mythics", "Mythic Rare", "Mythic Rares", "MR", "Mr":
 			view = "mythics"
 		case "Special", "Specials", "S":
 			view = "specials"
@@ -161,10 +163,10 @@ func filterRarity(search sq.SelectBuilder, rs []string) sq.SelectBuilder {
 	return search
 }
 
-func avg(search sq.SelectBuilder, arg string) string {
+func avg(ctx context.Context, db *sql.DB, search sq.SelectBuilder, arg string) string {
 	var res float64
-	err := queryOnSubSelect(sq.Select("avg("+arg+")"), search).
-		QueryRow().
+	err := queryOnSubSelect(db, sq.Select("avg("+arg+")"), search).
+		QueryRowContext(ctx).
 		Scan(&res)
 
 	if err != nil {
@@ -174,10 +176,10 @@ func avg(search sq.SelectBuilder, arg string) string {
 	return fmt.Sprintf("Average %s: %f\n", arg, res)
 }
 
-func count(search sq.SelectBuilder, arg string) string {
+func count(ctx context.Context, db *sql.DB, search sq.SelectBuilder, arg string) string {
 	var res int
-	err := queryOnSubSelect(sq.Select("count("+arg+")"), search).
-		QueryRow().
+	err := queryOnSubSelect(db, sq.Select("count("+arg+")"), search).
+		QueryRowContext(ctx).
 		Scan(&res)
 
 	if err != nil {
@@ -187,11 +189,11 @@ func count(search sq.SelectBuilder, arg string) string {
 	return fmt.Sprintf("Count: %d\n", res)
 }
 
-func sum(search sq.SelectBuilder, arg string) string {
+func sum(ctx context.Context, db *sql.DB, search sq.SelectBuilder, arg string) string {
 	var res float64
 
-	err := queryOnSubSelect(sq.Select("sum("+arg+")"), search).
-		QueryRow().
+	err := queryOnSubSelect(db, sq.Select("sum("+arg+")"), search).
+		QueryRowContext(ctx).
 		Scan(&res)
 
 	if err != nil {
@@ -201,14 +203,14 @@ func sum(search sq.SelectBuilder, arg string) string {
 	return fmt.Sprintf("Sum %s: %f\n", arg, res)
 }
 
-func min(search sq.SelectBuilder, arg string) string {
+func min(ctx context.Context, db *sql.DB, search sq.SelectBuilder, arg string) string {
 	var (
 		res  int
 		id   string
 		name string
 	)
-	err := queryOnSubSelect(sq.Select("min("+arg+")", "multiverse_id", "name"), search).
-		QueryRow().
+	err := queryOnSubSelect(db, sq.Select("min("+arg+")", "multiverse_id", "name"), search).
+		QueryRowContext(ctx).
 		Scan(&res, &id, &name)
 
 	if err != nil {
@@ -217,14 +219,14 @@ func min(search sq.SelectBuilder, arg string) string {
 	return fmt.Sprintf("Minimum %s: %s %s\n", arg, name, imageFromMID(id))
 }
 
-func max(search sq.SelectBuilder, arg string) string {
+func max(ctx context.Context, db *sql.DB, search sq.SelectBuilder, arg string) string {
 	var (
 		res  int
 		id   string
 		name string
 	)
-	err := queryOnSubSelect(sq.Select("max("+arg+")", "multiverse_id", "name"), search).
-		QueryRow().
+	err := queryOnSubSelect(db, sq.Select("max("+arg+")", "multiverse_id", "name"), search).
+		QueryRowContext(ctx).
 		Scan(&res, &id, &name)
 
 	if err != nil {
@@ -238,7 +240,7 @@ func imageFromMID(id string) string {
 	return fmt.Sprintf("http://gatherer.wizards.com/Handlers/Image.ashx?multiverseid=%s&type=card", id)
 }
 
-func queryOnSubSelect(query sq.SelectBuilder, sub sq.SelectBuilder) sq.SelectBuilder {
+func queryOnSubSelect(db *sql.DB, query sq.SelectBuilder, sub sq.SelectBuilder) sq.SelectBuilder {
 	return query.FromSelect(sub.GroupBy("name"), "sub").
 		Where("multiverse_id != 0").
 		RunWith(db)
@@ -303,7 +305,7 @@ func strFilter(ss []string, f func(string) bool) []string {
 
 func splitNegatives(ss []string) ([]string, []string) {
 	matches := strFilter(ss, func(s string) bool {
-		return s[0] != '!'
+		return len(s) != 0 && s[0] != '!'
 	})
 
 	non := strFilter(ss, func(s string) bool {
@@ -0,0 +1,233 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/komon/gosukebot/config"
+	"github.com/komon/gosukebot/transport"
+)
+
+// bucket is how many responses are left in a token bucket as of last
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// buckets is a set of token buckets sharing the same settings
+type buckets struct {
+	cfg config.Bucket
+	m   map[string]*bucket
+}
+
+// wait refills key's bucket up to now and returns how long until it has
+// a response to spare, 0 if it has one already
+func (bs *buckets) wait(key string, now time.Time) time.Duration {
+	if bs.cfg.Burst <= 0 {
+		return 0
+	}
+	b, ok := bs.m[key]
+	if !ok {
+		b = &bucket{tokens: float64(bs.cfg.Burst), last: now}
+		bs.m[key] = b
+	}
+	if every := bs.cfg.Every.Duration; every > 0 {
+		b.tokens += float64(now.Sub(b.last)) / float64(every)
+	}
+	if b.tokens > float64(bs.cfg.Burst) {
+		b.tokens = float64(bs.cfg.Burst)
+	}
+	b.last = now
+	if b.tokens >= 1 {
+		return 0
+	}
+	if bs.cfg.Every.Duration <= 0 {
+		return time.Duration(1<<63 - 1)
+	}
+	return time.Duration((1 - b.tokens) * float64(bs.cfg.Every.Duration))
+}
+
+func (bs *buckets) take(key string) {
+	if b, ok := bs.m[key]; ok {
+		b.tokens--
+	}
+}
+
+// sweep forgets the buckets that have refilled by now, a new one starts
+// out full anyway
+func (bs *buckets) sweep(now time.Time) {
+	every := bs.cfg.Every.Duration
+	if every <= 0 {
+		return
+	}
+	for key, b := range bs.m {
+		if b.tokens+float64(now.Sub(b.last))/float64(every) >= float64(bs.cfg.Burst) {
+			delete(bs.m, key)
+		}
+	}
+}
+
+// how often the limiter forgets whatever no longer limits anybody
+const sweepInterval = time.Minute
+
+// chargedKey is the context key for whether the message being handled has
+// been counted against the limits yet
+type chargedKey struct{}
+
+// withCharge marks ctx as belonging to a single message, so the limits
+// only count it once however many handlers respond to it
+func withCharge(ctx context.Context) context.Context {
+	return context.WithValue(ctx, chargedKey{}, new(bool))
+}
+
+// limiter is the state behind RateLimit, shared by every handler
+type limiter struct {
+	mu                    sync.Mutex
+	user, channel, global buckets
+	cooldowns             map[string]time.Duration
+	// cooling is when each handler's cooldown in each channel is over
+	cooling map[string]time.Time
+	// noticed is who's been told to slow down since they were last let
+	// through, so they're only told once, and until when
+	noticed map[string]time.Time
+	swept   time.Time
+	now     func() time.Time
+}
+
+func newLimiter(cfg config.Limits) *limiter {
+	l := &limiter{
+		user:      buckets{cfg.User, map[string]*bucket{}},
+		channel:   buckets{cfg.Channel, map[string]*bucket{}},
+		global:    buckets{cfg.Global, map[string]*bucket{}},
+		cooldowns: map[string]time.Duration{},
+		cooling:   map[string]time.Time{},
+		noticed:   map[string]time.Time{},
+		now:       time.Now,
+	}
+	for name, d := range cfg.Cooldowns {
+		l.cooldowns[name] = d.Duration
+	}
+	return l
+}
+
+// allow reports how long the user has to wait before handler can respond
+// to msg, and if it's 0 counts the response against every limit. The
+// buckets are only charged once per message, when charged is set it's
+// whether they have been already
+func (l *limiter) allow(handler string, msg transport.Message, charged *bool) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := l.now()
+	l.sweep(now)
+	key := handler + "\x00" + msg.Channel
+	paid := charged != nil && *charged
+
+	var wait time.Duration
+	if until, ok := l.cooling[key]; ok && now.Before(until) {
+		wait = until.Sub(now)
+	}
+	if !paid {
+		for _, w := range []time.Duration{
+			l.user.wait(msg.User, now),
+			l.channel.wait(msg.Channel, now),
+			l.global.wait("", now),
+		} {
+			if w > wait {
+				wait = w
+			}
+		}
+	}
+	if wait > 0 {
+		return wait
+	}
+
+	if !paid {
+		l.user.take(msg.User)
+		l.channel.take(msg.Channel)
+		l.global.take("")
+		if charged != nil {
+			*charged = true
+		}
+	}
+	if cd := l.cooldowns[handler]; cd > 0 {
+		l.cooling[key] = now.Add(cd)
+	}
+	delete(l.noticed, msg.User)
+	return 0
+}
+
+// sweep forgets the buckets, cooldowns and notices that no longer limit
+// anybody, so they don't pile up for every user and channel ever seen
+func (l *limiter) sweep(now time.Time) {
+	if now.Sub(l.swept) < sweepInterval {
+		return
+	}
+	l.swept = now
+	for _, bs := range []*buckets{&l.user, &l.channel, &l.global} {
+		bs.sweep(now)
+	}
+	for key, until := range l.cooling {
+		if !now.Before(until) {
+			delete(l.cooling, key)
+		}
+	}
+	for user, until := range l.noticed {
+		if !now.Before(until) {
+			delete(l.noticed, user)
+		}
+	}
+}
+
+// notice reports whether user should be told they're limited for wait,
+// which is only the first time since they were last let through
+func (l *limiter) notice(user string, wait time.Duration) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := l.now()
+	if until, ok := l.noticed[user]; ok && now.Before(until) {
+		return false
+	}
+	l.noticed[user] = now.Add(wait)
+	return true
+}
+
+// RateLimit holds responses to the limits in cfg: token buckets per user,
+// per channel and overall, and a cooldown per handler in each channel.
+// Limited responses are dropped, or replaced by an ephemeral notice
+// saying how long to wait if cfg.Notice is set
+func RateLimit(cfg config.Limits, logger *slog.Logger) Middleware {
+	l := newLimiter(cfg)
+	return l.middleware(cfg.Notice, logger)
+}
+
+func (l *limiter) middleware(notice bool, logger *slog.Logger) Middleware {
+	return func(info Info, next RespondFunc) RespondFunc {
+		return func(ctx context.Context, msg transport.Message, matches []string) (transport.Response, error) {
+			charged, _ := ctx.Value(chargedKey{}).(*bool)
+			wait := l.allow(info.Name, msg, charged)
+			if wait == 0 {
+				return next(ctx, msg, matches)
+			}
+			logger.Info("rate limited", "handler", info.Name, "channel", msg.Channel,
+				"user", msg.User, "wait", wait)
+			if !notice || !l.notice(msg.User, wait) {
+				return transport.Response{}, nil
+			}
+			return transport.Response{
+				Text:      fmt.Sprintf("Slow down! Try again in %s", roundUp(wait)),
+				Ephemeral: true,
+			}, nil
+		}
+	}
+}
+
+// roundUp rounds d up to the second, so nobody's told to wait 0s
+func roundUp(d time.Duration) time.Duration {
+	if d > time.Hour {
+		return d.Round(time.Hour)
+	}
+	return (d + time.Second - 1).Truncate(time.Second)
+}
@@ -0,0 +1,126 @@
+package handler
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/komon/gosukebot/config"
+	"github.com/komon/gosukebot/logging"
+	"github.com/komon/gosukebot/transport"
+)
+
+// clock is a time that only moves when told to
+type clock struct {
+	t time.Time
+}
+
+func (c *clock) now() time.Time {
+	return c.t
+}
+
+func limited(cfg config.Limits) (*clock, RespondFunc, RespondFunc) {
+	c := &clock{time.Unix(0, 0)}
+	l := newLimiter(cfg)
+	l.now = c.now
+	mw := []Middleware{l.middleware(cfg.Notice, logging.Discard())}
+	return c, chain(Info{Name: "ora"}, respondWith("ora"), mw),
+		chain(Info{Name: "muda"}, respondWith("muda"), mw)
+}
+
+func say(respond RespondFunc, user, channel string) string {
+	resp, _ := respond(context.Background(), transport.Message{User: user, Channel: channel}, nil)
+	return resp.Text
+}
+
+func TestRateLimitUserBucket(t *testing.T) {
+	c, ora, muda := limited(config.Limits{User: config.Bucket{Burst: 2, Every: config.Duration{Duration: 10 * time.Second}}})
+	if say(ora, "U1", "C1") != "ora" || say(muda, "U1", "C1") != "muda" {
+		t.Fatal("expected the first two responses through")
+	}
+	if got := say(ora, "U1", "C1"); got != "" {
+		t.Errorf("expected U1 to be limited, got %q", got)
+	}
+	if got := say(ora, "U2", "C1"); got != "ora" {
+		t.Errorf("U2 shouldn't be limited by U1, got %q", got)
+	}
+	c.t = c.t.Add(10 * time.Second)
+	if got := say(ora, "U1", "C1"); got != "ora" {
+		t.Errorf("expected U1's bucket to refill, got %q", got)
+	}
+}
+
+func TestRateLimitChannelAndGlobal(t *testing.T) {
+	_, ora, _ := limited(config.Limits{
+		Channel: config.Bucket{Burst: 1, Every: config.Duration{Duration: time.Minute}},
+		Global:  config.Bucket{Burst: 2, Every: config.Duration{Duration: time.Minute}},
+	})
+	say(ora, "U1", "C1")
+	if got := say(ora, "U2", "C1"); got != "" {
+		t.Errorf("expected C1 to be limited, got %q", got)
+	}
+	say(ora, "U1", "C2")
+	if got := say(ora, "U1", "C3"); got != "" {
+		t.Errorf("expected the global limit to kick in, got %q", got)
+	}
+}
+
+func TestCooldownNotice(t *testing.T) {
+	c, ora, muda := limited(config.Limits{
+		Cooldowns: map[string]config.Duration{"ora": {Duration: 30 * time.Second}},
+		Notice:    true,
+	})
+	say(ora, "U1", "C1")
+	if got := say(muda, "U1", "C1"); got != "muda" {
+		t.Errorf("cooldown should only apply to ora, got %q", got)
+	}
+	if got := say(ora, "U1", "C2"); got != "ora" {
+		t.Errorf("cooldown should only apply in C1, got %q", got)
+	}
+
+	c.t = c.t.Add(20*time.Second + time.Millisecond)
+	resp, _ := ora(context.Background(), transport.Message{User: "U1", Channel: "C1"}, nil)
+	if !resp.Ephemeral || resp.Text != "Slow down! Try again in 10s" {
+		t.Errorf("expected an ephemeral notice, got %+v", resp)
+	}
+	if got := say(ora, "U1", "C1"); got != "" {
+		t.Errorf("expected only one notice, got %q", got)
+	}
+}
+
+func TestRateLimitChargesOncePerMessage(t *testing.T) {
+	_, ora, muda := limited(config.Limits{User: config.Bucket{Burst: 1, Every: config.Duration{Duration: time.Minute}}})
+	ctx := withCharge(context.Background())
+	msg := transport.Message{User: "U1", Channel: "C1"}
+	for _, respond := range []RespondFunc{ora, muda} {
+		if resp, _ := respond(ctx, msg, nil); resp.Text == "" {
+			t.Error("expected both handlers to answer the one message")
+		}
+	}
+	if got := say(ora, "U1", "C1"); got != "" {
+		t.Errorf("expected the next message to be limited, got %q", got)
+	}
+}
+
+func TestRateLimitForgetsIdleEntries(t *testing.T) {
+	cfg := config.Limits{
+		User:      config.Bucket{Burst: 1, Every: config.Duration{Duration: 10 * time.Second}},
+		Channel:   config.Bucket{Burst: 5, Every: config.Duration{Duration: 10 * time.Second}},
+		Cooldowns: map[string]config.Duration{"ora": {Duration: 30 * time.Second}},
+	}
+	c := &clock{time.Unix(0, 0)}
+	l := newLimiter(cfg)
+	l.now = c.now
+	for _, user := range []string{"U1", "U2", "U3"} {
+		l.allow("ora", transport.Message{User: user, Channel: "C" + user}, nil)
+	}
+	l.allow("ora", transport.Message{User: "U1", Channel: "C1"}, nil)
+	l.notice("U1", 10*time.Second)
+
+	c.t = c.t.Add(time.Hour)
+	l.allow("muda", transport.Message{User: "U4", Channel: "C4"}, nil)
+	if len(l.user.m) != 1 || len(l.channel.m) != 1 || len(l.cooling) != 0 || len(l.noticed) != 0 {
+		t.Errorf("expected only U4's buckets to be left, got %d users, %d channels, %d cooldowns and %d notices",
+			len(l.user.m), len(l.channel.m), len(l.cooling), len(l.noticed))
+	}
+}
@@ -0,0 +1,456 @@
+package responders
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/rand"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/komon/gosukebot/config"
+	"github.com/komon/gosukebot/handler"
+	"github.com/komon/gosukebot/transport"
+)
+
+func init() {
+	handler.Register("responders", 0, "canned responses to phrases listed in responders.toml",
+		func(cfg config.Config, logger *slog.Logger) (handler.Handler, error) {
+			return New(cfg.Responders, logger)
+		})
+}
+
+type responder struct {
+	re        *regexp.Regexp
+	responses []*template.Template
+	// weights has one weight for every response
+	weights  []int
+	noRepeat bool
+	cooldown time.Duration
+	// chance is the percentage of matches answered, 0 for all of them
+	chance int
+	// hours and days are when the responder answers, nil for any time
+	hours *span
+	days  map[time.Weekday]bool
+}
+
+// span is a time of day range in minutes after midnight, wrapping past
+// midnight when from is later than to
+type span struct {
+	from, to int
+}
+
+// data is what a response template can use
+type data struct {
+	// User and Channel are the names of who said the message and where,
+	// or their IDs if the transport doesn't know the names
+	User    string
+	Channel string
+	Time    time.Time
+	// Match is all the text the regexp matched, Groups is every group by
+	// number with 0 being the whole match, and Named has the named ones
+	Match  string
+	Groups []string
+	Named  map[string]string
+}
+
+// how often the responders file is checked for changes
+const watchInterval = 2 * time.Second
+
+// RespondersHandler satisfies the handler.Handler interface, answering
+// messages that match one of the regexps in the responders file with one
+// of its responses picked at random, see config.responder for how the
+// picking can be tuned. Responses are text/template
+// templates, see data for what they can use. The file is watched, and reloaded
+// whenever it changes
+type RespondersHandler struct {
+	path   string
+	logger *slog.Logger
+	done   chan struct{}
+
+	mu sync.RWMutex
+	rs []responder
+
+	// last is when each responder last answered in each channel, for
+	// cooldowns, and previous the response it gave, for not repeating it
+	lastMu   sync.Mutex
+	last     map[string]time.Time
+	previous map[string]int
+
+	now  func() time.Time
+	intn func(n int) int
+}
+
+// New returns a RespondersHandler for the responders file at path,
+// watching it for changes until it's closed
+func New(path string, logger *slog.Logger) (*RespondersHandler, error) {
+	return newHandler(path, logger, watchInterval)
+}
+
+func newHandler(path string, logger *slog.Logger, interval time.Duration) (*RespondersHandler, error) {
+	rh := &RespondersHandler{
+		path:     path,
+		logger:   logger,
+		done:     make(chan struct{}),
+		last:     map[string]time.Time{},
+		previous: map[string]int{},
+		now:      time.Now,
+		intn:     rand.Intn,
+	}
+	stamp := rh.stamp()
+	if err := rh.Reload(); err != nil {
+		return nil, err
+	}
+	go rh.watch(stamp, interval)
+	return rh, nil
+}
+
+// Reload re-reads the responders file. Every responder is checked before
+// any of them are swapped in, so a mistake in the file leaves the ones
+// already loaded alone, and the error says everything that's wrong
+func (rh *RespondersHandler) Reload() error {
+	loaded, err := config.PopulateResponders(rh.path)
+	if err != nil {
+		return err
+	}
+	var (
+		rs   []responder
+		errs []error
+	)
+	for i, r := range loaded {
+		re, err := regexp.Compile(r.Regexp)
+		switch {
+		case err != nil:
+			errs = append(errs, fmt.Errorf("responder %d: bad regexp %q: %v", i+1, r.Regexp, err))
+			continue
+		case r.Regexp == "":
+			errs = append(errs, fmt.Errorf("responder %d: no regexp", i+1))
+			continue
+		case len(r.Responses) == 0:
+			errs = append(errs, fmt.Errorf("responder %d: %q has no responses", i+1, r.Regexp))
+			continue
+		case r.Chance < 0 || r.Chance > 100:
+			errs = append(errs, fmt.Errorf("responder %d: chance %d isn't a percentage", i+1, r.Chance))
+			continue
+		}
+		responses, err := parse(i, r.Responses)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		weights, werr := weigh(r.Weights, len(r.Responses))
+		hours, herr := parseHours(r.Hours)
+		days, derr := parseDays(r.Days)
+		if werr != nil || herr != nil || derr != nil {
+			for _, err := range []error{werr, herr, derr} {
+				if err != nil {
+					errs = append(errs, fmt.Errorf("responder %d: %v", i+1, err))
+				}
+			}
+			continue
+		}
+		rs = append(rs, responder{
+			re:        re,
+			responses: responses,
+			weights:   weights,
+			noRepeat:  r.NoRepeat,
+			cooldown:  r.Cooldown.Duration,
+			chance:    r.Chance,
+			hours:     hours,
+			days:      days,
+		})
+	}
+	if len(errs) != 0 {
+		return errors.Join(errs...)
+	}
+
+	rh.mu.Lock()
+	rh.rs = rs
+	rh.mu.Unlock()
+	rh.logger.Info("loaded responders", "path", rh.path, "count", len(rs))
+	return nil
+}
+
+// parse parses the responses of the i'th responder as templates. Missing
+// named groups come out empty rather than as "<no value>"
+func parse(i int, responses []string) ([]*template.Template, error) {
+	var (
+		ts   []*template.Template
+		errs []error
+	)
+	for j, response := range responses {
+		t, err := template.New("").Option("missingkey=zero").Parse(response)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("responder %d: response %d: %v", i+1, j+1, err))
+			continue
+		}
+		ts = append(ts, t)
+	}
+	return ts, errors.Join(errs...)
+}
+
+// weigh gives each of n responses its weight, 1 if it doesn't have one
+func weigh(weights []int, n int) ([]int, error) {
+	if len(weights) > n {
+		return nil, fmt.Errorf("%d weights for %d responses", len(weights), n)
+	}
+	ws := make([]int, n)
+	total := 0
+	for i := range ws {
+		ws[i] = 1
+		if i < len(weights) {
+			ws[i] = weights[i]
+		}
+		if ws[i] < 0 {
+			return nil, fmt.Errorf("response %d has a negative weight", i+1)
+		}
+		total += ws[i]
+	}
+	if total == 0 {
+		return nil, fmt.Errorf("every response weighs 0")
+	}
+	return ws, nil
+}
+
+// parseHours parses a time of day range like "09:00-17:00", the end
+// isn't included. An empty range is nil, meaning any time
+func parseHours(hours string) (*span, error) {
+	if hours == "" {
+		return nil, nil
+	}
+	from, to, ok := strings.Cut(hours, "-")
+	if !ok {
+		return nil, fmt.Errorf("hours %q should look like 09:00-17:00", hours)
+	}
+	var minutes [2]int
+	for i, s := range []string{from, to} {
+		t, err := time.Parse("15:04", strings.TrimSpace(s))
+		if err != nil {
+			return nil, fmt.Errorf("hours %q should look like 09:00-17:00", hours)
+		}
+		minutes[i] = t.Hour()*60 + t.Minute()
+	}
+	if minutes[0] == minutes[1] {
+		return nil, fmt.Errorf("hours %q start and end at the same time", hours)
+	}
+	return &span{minutes[0], minutes[1]}, nil
+}
+
+// contains reports whether t's time of day is in s
+func (s *span) contains(t time.Time) bool {
+	m := t.Hour()*60 + t.Minute()
+	if s.from < s.to {
+		return s.from <= m && m < s.to
+	}
+	return m >= s.from || m < s.to
+}
+
+// parseDays parses day names, either in full or their first three
+// letters. No days is nil, meaning every day
+func parseDays(names []string) (map[time.Weekday]bool, error) {
+	if len(names) == 0 {
+		return nil, nil
+	}
+	days := map[time.Weekday]bool{}
+	for _, name := range names {
+		found := false
+		for d := time.Sunday; d <= time.Saturday; d++ {
+			full := strings.ToLower(d.String())
+			if n := strings.ToLower(name); n == full || n == full[:3] {
+				days[d], found = true, true
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("%q isn't a day", name)
+		}
+	}
+	return days, nil
+}
+
+// stamp identifies the version of the responders file on disk, it's
+// empty if the file can't be read
+func (rh *RespondersHandler) stamp() string {
+	fi, err := os.Stat(rh.path)
+	if err != nil {
+		return ""
+	}
+	return fmt.Sprintf("%d %d", fi.ModTime().UnixNano(), fi.Size())
+}
+
+// watch reloads the responders file whenever it changes, until the
+// handler is closed. A file that doesn't load is logged, the admins are
+// alerted, and it's skipped until it changes again
+func (rh *RespondersHandler) watch(stamp string, interval time.Duration) {
+	tick := time.NewTicker(interval)
+	defer tick.Stop()
+	for {
+		select {
+		case <-rh.done:
+			return
+		case <-tick.C:
+		}
+		now := rh.stamp()
+		if now == stamp || now == "" {
+			continue
+		}
+		stamp = now
+		if err := rh.Reload(); err != nil {
+			rh.logger.Error("responders file changed but didn't load, keeping the old ones",
+				"path", rh.path, "err", err)
+			handler.Alert(fmt.Sprintf("%s changed but didn't load, keeping the old responders: %v", rh.path, err))
+		}
+	}
+}
+
+// Close stops watching the responders file
+func (rh *RespondersHandler) Close() error {
+	close(rh.done)
+	return nil
+}
+
+// Match returns the regexps of every responder that matches msg
+func (rh *RespondersHandler) Match(msg string) []string {
+	rh.mu.RLock()
+	defer rh.mu.RUnlock()
+	var matches []string
+	for _, r := range rh.rs {
+		if r.re.MatchString(msg) {
+			matches = append(matches, r.re.String())
+		}
+	}
+	return matches
+}
+
+// Respond picks a response for each matched responder. Responders that
+// have gone away in a reload since Match, are outside their hours or days,
+// lose their roll of the dice or are cooling down in the channel, are
+// skipped
+func (rh *RespondersHandler) Respond(ctx context.Context, msg transport.Message, matches []string) (transport.Response, error) {
+	rh.mu.RLock()
+	defer rh.mu.RUnlock()
+	now := rh.now()
+	response := ""
+	for _, match := range matches {
+		for _, r := range rh.rs {
+			if r.re.String() != match || len(r.responses) == 0 {
+				continue
+			}
+			if !r.on(now) {
+				rh.logger.Debug("responder off at this time", "regexp", match)
+				break
+			}
+			if r.chance > 0 && rh.intn(100) >= r.chance {
+				rh.logger.Debug("responder let it pass", "regexp", match, "chance", r.chance)
+				break
+			}
+			if !rh.cool(r, msg.Channel, now) {
+				rh.logger.Debug("responder cooling down", "regexp", match, "channel", msg.Channel)
+				break
+			}
+			text, err := r.render(r.responses[rh.pick(r, msg.Channel)], msg, now)
+			if err != nil {
+				rh.logger.Error("responder template error", "regexp", match, "err", err)
+				break
+			}
+			response += text + "\n"
+			break
+		}
+	}
+	return transport.Response{Text: response}, nil
+}
+
+// on reports whether r answers at now
+func (r responder) on(now time.Time) bool {
+	if r.days != nil && !r.days[now.Weekday()] {
+		return false
+	}
+	return r.hours == nil || r.hours.contains(now)
+}
+
+// pick picks which of r's responses to give in channel, by weight, and
+// leaving out the one it gave last time if it shouldn't repeat itself
+func (rh *RespondersHandler) pick(r responder, channel string) int {
+	rh.lastMu.Lock()
+	defer rh.lastMu.Unlock()
+	key := r.re.String() + "\x00" + channel
+	prev, said := rh.previous[key]
+	skip := func(i int) bool {
+		return r.noRepeat && said && i == prev && len(r.weights) > 1
+	}
+	total := 0
+	for i, w := range r.weights {
+		if !skip(i) {
+			total += w
+		}
+	}
+	// the last response is the only one with any weight
+	if total == 0 {
+		return prev
+	}
+	n, picked := rh.intn(total), 0
+	for i, w := range r.weights {
+		if skip(i) {
+			continue
+		}
+		if n < w {
+			picked = i
+			break
+		}
+		n -= w
+	}
+	if r.noRepeat {
+		rh.previous[key] = picked
+	}
+	return picked
+}
+
+// render runs the response template t for msg
+func (r responder) render(t *template.Template, msg transport.Message, now time.Time) (string, error) {
+	d := data{
+		User:    or(msg.UserName, msg.User),
+		Channel: or(msg.ChannelName, msg.Channel),
+		Time:    now,
+		Named:   map[string]string{},
+	}
+	if m := r.re.FindStringSubmatch(msg.Text); m != nil {
+		d.Match, d.Groups = m[0], m
+		for i, name := range r.re.SubexpNames() {
+			if name != "" {
+				d.Named[name] = m[i]
+			}
+		}
+	}
+	var b strings.Builder
+	if err := t.Execute(&b, d); err != nil {
+		return "", err
+	}
+	return b.String(), nil
+}
+
+func or(s, fallback string) string {
+	if s != "" {
+		return s
+	}
+	return fallback
+}
+
+// cool reports whether r is done cooling down in channel, and if it is
+// starts the cooldown over
+func (rh *RespondersHandler) cool(r responder, channel string, now time.Time) bool {
+	if r.cooldown <= 0 {
+		return true
+	}
+	rh.lastMu.Lock()
+	defer rh.lastMu.Unlock()
+	key := r.re.String() + "\x00" + channel
+	if last, ok := rh.last[key]; ok && now.Sub(last) < r.cooldown {
+		return false
+	}
+	rh.last[key] = now
+	return true
+}
@@ -0,0 +1,310 @@
+package responders
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/komon/gosukebot/handler"
+	"github.com/komon/gosukebot/logging"
+	"github.com/komon/gosukebot/transport"
+)
+
+// writeResponders writes a responders file to path, failing the test if
+// it can't
+func writeResponders(t *testing.T, path, toml string) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(toml), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// newTestHandler returns a handler for a responders file holding toml,
+// which is closed when the test is done
+func newTestHandler(t *testing.T, toml string) *RespondersHandler {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "responders.toml")
+	writeResponders(t, path, toml)
+	rh, err := New(path, logging.Discard())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { rh.Close() })
+	return rh
+}
+
+func TestResponders(t *testing.T) {
+	rh := newTestHandler(t, `
+[[responders]]
+regexp = 'menacing'
+responses = ["ゴゴゴ"]
+`)
+
+	matches := rh.Match("something menacing")
+	resp, _ := rh.Respond(context.Background(), transport.Message{}, matches)
+	if resp.Text != "ゴゴゴ\n" {
+		t.Errorf("expected ゴゴゴ, got %q", resp.Text)
+	}
+	if rh.Match("nothing to see") != nil {
+		t.Error("expected no match")
+	}
+
+	writeResponders(t, rh.path, `
+[[responders]]
+regexp = 'good grief'
+responses = ["やれやれだぜ..."]
+`)
+	if err := rh.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if rh.Match("something menacing") != nil || rh.Match("good grief") == nil {
+		t.Error("reload didn't pick up the new responders")
+	}
+
+	writeResponders(t, rh.path, `
+[[responders]]
+regexp = 'ora ora'
+responses = ["オラオラ"]
+
+[[responders]]
+regexp = 'muda('
+responses = ["無駄"]
+
+[[responders]]
+regexp = 'za warudo'
+`)
+	err := rh.Reload()
+	if err == nil || !strings.Contains(err.Error(), "responder 2: bad regexp") ||
+		!strings.Contains(err.Error(), `responder 3: "za warudo" has no responses`) {
+		t.Errorf("expected both bad responders to be reported, got %v", err)
+	}
+	if rh.Match("good grief") == nil || rh.Match("ora ora") != nil {
+		t.Error("a bad reload should leave the old responders alone")
+	}
+
+	writeResponders(t, rh.path, `[[responders]`)
+	if err := rh.Reload(); err == nil {
+		t.Error("expected a toml error")
+	}
+}
+
+func TestWatch(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "responders.toml")
+	write := func(s string, mtime time.Time) {
+		writeResponders(t, path, s)
+		if err := os.Chtimes(path, mtime, mtime); err != nil {
+			t.Fatal(err)
+		}
+	}
+	start := time.Now()
+	write("[[responders]]\nregexp = 'menacing'\nresponses = ['ゴゴゴ']\n", start)
+	rh, err := newHandler(path, logging.Discard(), time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rh.Close()
+	alerts := make(chan string, 1)
+	handler.SetAlert(func(text string) {
+		select {
+		case alerts <- text:
+		default:
+		}
+	})
+	defer handler.SetAlert(nil)
+
+	waitFor := func(text string) bool {
+		for deadline := time.Now().Add(time.Second); time.Now().Before(deadline); time.Sleep(time.Millisecond) {
+			if rh.Match(text) != nil {
+				return true
+			}
+		}
+		return false
+	}
+	write("[[responders]]\nregexp = 'good grief'\nresponses = ['やれやれだぜ...']\n", start.Add(time.Second))
+	if !waitFor("good grief") {
+		t.Fatal("expected the changed file to be picked up")
+	}
+
+	write("[[responders]]\nregexp = 'muda('\nresponses = ['無駄']\n", start.Add(2*time.Second))
+	select {
+	case text := <-alerts:
+		if !strings.Contains(text, "bad regexp") {
+			t.Errorf("expected the admins to hear what's wrong, got %q", text)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected the admins to be alerted about the broken file")
+	}
+	write("[[responders]]\nregexp = 'muda'\nresponses = ['無駄']\n", start.Add(3*time.Second))
+	if !waitFor("muda") {
+		t.Fatal("expected a fixed file to be picked up after a broken one")
+	}
+}
+
+func TestCooldown(t *testing.T) {
+	rh := newTestHandler(t, `
+[[responders]]
+regexp = 'menacing'
+cooldown = "1m"
+responses = ["ゴゴゴ"]
+`)
+
+	respond := func(channel string) string {
+		msg := transport.Message{Text: "menacing", Channel: channel}
+		resp, _ := rh.Respond(context.Background(), msg, rh.Match(msg.Text))
+		return resp.Text
+	}
+	if respond("C1") == "" || respond("C2") == "" {
+		t.Fatal("expected the first response in each channel")
+	}
+	if got := respond("C1"); got != "" {
+		t.Errorf("expected the responder to be cooling down, got %q", got)
+	}
+}
+
+func TestTemplates(t *testing.T) {
+	rh := newTestHandler(t, `
+[[responders]]
+regexp = '^hello,? (?P<who>\w+)'
+responses = ["Hello to you too, {{.User}}, not {{.Named.who}} in {{.Channel}}"]
+
+[[responders]]
+regexp = '(ora|muda) x(\d+)'
+responses = ["{{index .Groups 1}} {{index .Groups 2}} times, {{.Match}}{{.Named.missing}}"]
+`)
+
+	tests := []struct {
+		msg  transport.Message
+		want string
+	}{
+		{transport.Message{Text: "hello jojo", User: "U1", UserName: "jotaro", Channel: "C1", ChannelName: "general"},
+			"Hello to you too, jotaro, not jojo in general\n"},
+		{transport.Message{Text: "hello, jojo", User: "U1", Channel: "C1"},
+			"Hello to you too, U1, not jojo in C1\n"},
+		{transport.Message{Text: "muda x7"}, "muda 7 times, muda x7\n"},
+	}
+	for _, tt := range tests {
+		resp, _ := rh.Respond(context.Background(), tt.msg, rh.Match(tt.msg.Text))
+		if resp.Text != tt.want {
+			t.Errorf("%q: expected %q, got %q", tt.msg.Text, tt.want, resp.Text)
+		}
+	}
+
+	writeResponders(t, rh.path, `
+[[responders]]
+regexp = 'ora'
+responses = ["fine", "{{.User"]
+`)
+	if err := rh.Reload(); err == nil || !strings.Contains(err.Error(), "responder 1: response 2:") {
+		t.Errorf("expected the bad template to be reported, got %v", err)
+	}
+	if rh.Match("muda x7") == nil {
+		t.Error("a bad template should leave the old responders alone")
+	}
+}
+
+func TestPicking(t *testing.T) {
+	rh := newTestHandler(t, `
+[[responders]]
+regexp = 'weighed'
+responses = ["never", "always"]
+weights = [0]
+
+[[responders]]
+regexp = 'eh, jojo\?'
+responses = ["Yeah!", "Nah"]
+no_repeat = true
+
+[[responders]]
+regexp = 'sometimes'
+responses = ["now"]
+chance = 30
+
+[[responders]]
+regexp = 'late'
+responses = ["go to bed"]
+hours = "22:00-06:00"
+days = ["Sat", "sunday"]
+`)
+	roll := 0
+	rh.intn = func(n int) int { return roll % n }
+	rh.now = func() time.Time { return time.Date(2026, 10, 17, 23, 30, 0, 0, time.Local) }
+
+	respond := func(text, channel string) string {
+		msg := transport.Message{Text: text, Channel: channel}
+		resp, _ := rh.Respond(context.Background(), msg, rh.Match(text))
+		return resp.Text
+	}
+	for roll = 0; roll < 3; roll++ {
+		if got := respond("weighed", "C1"); got != "always\n" {
+			t.Errorf("roll %d: expected the only response with any weight, got %q", roll, got)
+		}
+	}
+
+	roll = 0
+	var said []string
+	for i := 0; i < 4; i++ {
+		said = append(said, respond("eh, jojo?", "C1"))
+	}
+	if strings.Join(said, "") != "Yeah!\nNah\nYeah!\nNah\n" {
+		t.Errorf("expected responses not to repeat, got %q", said)
+	}
+	if got := respond("eh, jojo?", "C2"); got != "Yeah!\n" {
+		t.Errorf("expected repeats to be kept track of by channel, got %q", got)
+	}
+
+	roll = 30
+	if got := respond("sometimes", "C1"); got != "" {
+		t.Errorf("expected a roll of 30 not to beat a 30%% chance, got %q", got)
+	}
+	roll = 29
+	if got := respond("sometimes", "C1"); got != "now\n" {
+		t.Errorf("expected a roll of 29 to beat a 30%% chance, got %q", got)
+	}
+
+	for _, tt := range []struct {
+		at   time.Time
+		want string
+	}{
+		{time.Date(2026, 10, 17, 23, 30, 0, 0, time.Local), "go to bed\n"},
+		{time.Date(2026, 10, 18, 5, 59, 0, 0, time.Local), "go to bed\n"},
+		{time.Date(2026, 10, 18, 6, 0, 0, 0, time.Local), ""},
+		{time.Date(2026, 10, 19, 23, 30, 0, 0, time.Local), ""},
+	} {
+		rh.now = func() time.Time { return tt.at }
+		if got := respond("late", "C1"); got != tt.want {
+			t.Errorf("%v: expected %q, got %q", tt.at, tt.want, got)
+		}
+	}
+
+	writeResponders(t, rh.path, `
+[[responders]]
+regexp = 'a'
+responses = ["a"]
+weights = [1, 2]
+
+[[responders]]
+regexp = 'b'
+responses = ["b"]
+chance = 150
+
+[[responders]]
+regexp = 'c'
+responses = ["c"]
+hours = "9-5"
+days = ["caturday"]
+`)
+	err := rh.Reload()
+	for _, want := range []string{
+		"responder 1: 2 weights for 1 responses",
+		"responder 2: chance 150 isn't a percentage",
+		`responder 3: hours "9-5" should look like 09:00-17:00`,
+		`responder 3: "caturday" isn't a day`,
+	} {
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("expected %q in %v", want, err)
+		}
+	}
+}
@@ -0,0 +1,79 @@
+package logging
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"log/slog"
+	"os"
+	"strings"
+
+	"gopkg.in/natefinch/lumberjack.v2"
+)
+
+// Config says where logs go and what they look like
+type Config struct {
+	// Level is one of debug, info, warn or error, defaulting to info
+	Level string
+	// Format is text or json, defaulting to text
+	Format string
+	// File is the log file, or "stderr" to log to stderr. Files are
+	// rotated once they reach MaxSizeMB, keeping MaxBackups old files
+	// for up to MaxAgeDays
+	File       string
+	MaxSizeMB  int `toml:"max_size_mb"`
+	MaxBackups int `toml:"max_backups"`
+	MaxAgeDays int `toml:"max_age_days"`
+}
+
+// New returns a logger writing where cfg says, and a Closer that flushes
+// and closes the log file when the bot is done with it
+func New(cfg Config) (*slog.Logger, io.Closer, error) {
+	var level slog.Level
+	if cfg.Level != "" {
+		if err := level.UnmarshalText([]byte(cfg.Level)); err != nil {
+			return nil, nil, fmt.Errorf("bad log level %q: %v", cfg.Level, err)
+		}
+	}
+
+	var out io.WriteCloser = nopCloser{os.Stderr}
+	if cfg.File != "" && cfg.File != "stderr" {
+		out = &lumberjack.Logger{
+			Filename:   cfg.File,
+			MaxSize:    cfg.MaxSizeMB,
+			MaxBackups: cfg.MaxBackups,
+			MaxAge:     cfg.MaxAgeDays,
+		}
+	}
+
+	opts := &slog.HandlerOptions{Level: level}
+	var h slog.Handler
+	switch strings.ToLower(cfg.Format) {
+	case "", "text":
+		h = slog.NewTextHandler(out, opts)
+	case "json":
+		h = slog.NewJSONHandler(out, opts)
+	default:
+		return nil, nil, fmt.Errorf("bad log format %q", cfg.Format)
+	}
+	return slog.New(h), out, nil
+}
+
+// Std adapts logger for libraries that want a *log.Logger, everything
+// they write is logged at the given level
+func Std(logger *slog.Logger, level slog.Level) *log.Logger {
+	return slog.NewLogLogger(logger.Handler(), level)
+}
+
+// Discard returns a logger that throws everything away, for tests
+func Discard() *slog.Logger {
+	return slog.New(slog.NewTextHandler(io.Discard, nil))
+}
+
+type nopCloser struct {
+	io.Writer
+}
+
+func (nopCloser) Close() error {
+	return nil
+}
@@ -7,5 +7,9 @@ import (
 )
 
 func main() {
-	os.Exit(bot.Run())
+	mode := ""
+	if len(os.Args) > 1 {
+		mode = os.Args[1]
+	}
+	os.Exit(bot.Run(mode))
 }
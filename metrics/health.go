@@ -0,0 +1,82 @@
+package metrics
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// how long all the health checks together get to answer
+const checkTimeout = 5 * time.Second
+
+var (
+	checkMu sync.Mutex
+	checks  = map[string]func(context.Context) error{}
+)
+
+// Check adds a health check under name, replacing any earlier one with
+// the same name. It should return an error saying what's wrong, or nil
+// if everything's fine
+func Check(name string, f func(context.Context) error) {
+	checkMu.Lock()
+	defer checkMu.Unlock()
+	checks[name] = f
+}
+
+// Health serves the result of every health check: 200 and ok if they all
+// pass, or 503 and what failed if any of them don't
+func Health() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), checkTimeout)
+		defer cancel()
+
+		checkMu.Lock()
+		fs := make(map[string]func(context.Context) error, len(checks))
+		names := make([]string, 0, len(checks))
+		for name, f := range checks {
+			fs[name] = f
+			names = append(names, name)
+		}
+		checkMu.Unlock()
+		sort.Strings(names)
+
+		var failed []string
+		for _, name := range names {
+			if err := fs[name](ctx); err != nil {
+				failed = append(failed, fmt.Sprintf("%s: %v", name, err))
+			}
+		}
+		w.Header().Set("Content-Type", "text/plain")
+		if len(failed) != 0 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			fmt.Fprintln(w, strings.Join(failed, "\n"))
+			return
+		}
+		fmt.Fprintln(w, "ok")
+	})
+}
+
+// Serve starts serving /metrics and /healthz on addr, it's up to the
+// caller to close the server
+func Serve(addr string, logger *slog.Logger) (*http.Server, error) {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", Handler())
+	mux.Handle("/healthz", Health())
+	srv := &http.Server{Handler: mux}
+	go func() {
+		if err := srv.Serve(l); err != http.ErrServerClosed {
+			logger.Error("metrics server error", "err", err)
+		}
+	}()
+	return srv, nil
+}
@@ -0,0 +1,255 @@
+// Package metrics keeps the bot's counters and histograms and serves them
+// in the Prometheus text format, along with a health check. Metrics
+// register themselves when they're made, usually in a package level var,
+// the same way handlers register themselves with the handler package
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Buckets are the default histogram buckets in seconds, from a
+// millisecond up to ten seconds
+var Buckets = []float64{.001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// metric is anything that can write itself out in the text format
+type metric interface {
+	name() string
+	write(w *bufio.Writer)
+}
+
+var (
+	mu       sync.Mutex
+	registry = map[string]metric{}
+)
+
+// register adds m to the metrics served, it panics if the name is taken
+func register(m metric) {
+	mu.Lock()
+	defer mu.Unlock()
+	if _, dup := registry[m.name()]; dup {
+		panic("metrics: " + m.name() + " registered twice")
+	}
+	registry[m.name()] = m
+}
+
+// desc is the part every metric has: a name, help text and label names
+type desc struct {
+	Name   string
+	Help   string
+	Labels []string
+}
+
+func (d desc) name() string {
+	return d.Name
+}
+
+func (d desc) header(w *bufio.Writer, typ string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", d.Name, d.Help, d.Name, typ)
+}
+
+// key joins label values into a map key, checking there's one for every
+// label name
+func (d desc) key(values []string) string {
+	if len(values) != len(d.Labels) {
+		panic(fmt.Sprintf("metrics: %s wants %d label values, got %d", d.Name, len(d.Labels), len(values)))
+	}
+	return strings.Join(values, "\x00")
+}
+
+// labels formats the labels for key, with extra added on the end
+func (d desc) labels(key string, extra ...string) string {
+	var pairs []string
+	if len(d.Labels) != 0 {
+		for i, v := range strings.Split(key, "\x00") {
+			pairs = append(pairs, d.Labels[i]+"="+quote(v))
+		}
+	}
+	for i := 0; i+1 < len(extra); i += 2 {
+		pairs = append(pairs, extra[i]+"="+quote(extra[i+1]))
+	}
+	if len(pairs) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+// labelEscaper escapes the only characters the exposition format wants
+// escaped in label values, everything else is written as it is
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// quote formats v as a label value
+func quote(v string) string {
+	return `"` + labelEscaper.Replace(v) + `"`
+}
+
+// Counter counts something that only goes up, separately for each set of
+// label values
+type Counter struct {
+	desc
+	mu     sync.Mutex
+	values map[string]float64
+}
+
+// NewCounter registers a counter. It panics if name is already taken
+func NewCounter(name, help string, labels ...string) *Counter {
+	c := &Counter{desc: desc{name, help, labels}, values: map[string]float64{}}
+	register(c)
+	return c
+}
+
+// Inc adds one to the count for the label values
+func (c *Counter) Inc(values ...string) {
+	c.Add(1, values...)
+}
+
+// Add adds n to the count for the label values
+func (c *Counter) Add(n float64, values ...string) {
+	key := c.key(values)
+	c.mu.Lock()
+	c.values[key] += n
+	c.mu.Unlock()
+}
+
+// Value returns the count for the label values
+func (c *Counter) Value(values ...string) float64 {
+	key := c.key(values)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.values[key]
+}
+
+func (c *Counter) write(w *bufio.Writer) {
+	c.header(w, "counter")
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.Labels) == 0 && len(c.values) == 0 {
+		fmt.Fprintf(w, "%s 0\n", c.Name)
+	}
+	for _, key := range sortedKeys(c.values) {
+		fmt.Fprintf(w, "%s%s %s\n", c.Name, c.labels(key), format(c.values[key]))
+	}
+}
+
+// Histogram counts observations, like how long something took, in
+// buckets, separately for each set of label values
+type Histogram struct {
+	desc
+	buckets []float64
+	mu      sync.Mutex
+	series  map[string]*series
+}
+
+type series struct {
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+// NewHistogram registers a histogram with the given bucket upper bounds,
+// which must be sorted. It panics if name is already taken
+func NewHistogram(name, help string, buckets []float64, labels ...string) *Histogram {
+	h := &Histogram{desc: desc{name, help, labels}, buckets: buckets, series: map[string]*series{}}
+	register(h)
+	return h
+}
+
+// Observe adds v to the histogram for the label values
+func (h *Histogram) Observe(v float64, values ...string) {
+	key := h.key(values)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	s, ok := h.series[key]
+	if !ok {
+		s = &series{counts: make([]uint64, len(h.buckets))}
+		h.series[key] = s
+	}
+	for i, le := range h.buckets {
+		if v <= le {
+			s.counts[i]++
+		}
+	}
+	s.count++
+	s.sum += v
+}
+
+// Since observes the seconds since start
+func (h *Histogram) Since(start time.Time, values ...string) {
+	h.Observe(time.Since(start).Seconds(), values...)
+}
+
+// Count returns how many observations there have been for the label
+// values
+func (h *Histogram) Count(values ...string) uint64 {
+	key := h.key(values)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if s, ok := h.series[key]; ok {
+		return s.count
+	}
+	return 0
+}
+
+func (h *Histogram) write(w *bufio.Writer) {
+	h.header(w, "histogram")
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	keys := make([]string, 0, len(h.series))
+	for key := range h.series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		s := h.series[key]
+		for i, le := range h.buckets {
+			fmt.Fprintf(w, "%s_bucket%s %d\n", h.Name, h.labels(key, "le", format(le)), s.counts[i])
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", h.Name, h.labels(key, "le", "+Inf"), s.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", h.Name, h.labels(key), format(s.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", h.Name, h.labels(key), s.count)
+	}
+}
+
+// Handler serves every registered metric in the Prometheus text format
+func Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		ms := make([]metric, 0, len(registry))
+		for _, m := range registry {
+			ms = append(ms, m)
+		}
+		mu.Unlock()
+		sort.Slice(ms, func(i, j int) bool { return ms[i].name() < ms[j].name() })
+
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		bw := bufio.NewWriter(w)
+		for _, m := range ms {
+			m.write(bw)
+		}
+		bw.Flush()
+	})
+}
+
+func sortedKeys(m map[string]float64) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func format(v float64) string {
+	if math.IsInf(v, 1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
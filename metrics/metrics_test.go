@@ -0,0 +1,88 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// unregister forgets the named metrics when the test is done, so it can
+// run again
+func unregister(t *testing.T, names ...string) {
+	t.Cleanup(func() {
+		mu.Lock()
+		defer mu.Unlock()
+		for _, name := range names {
+			delete(registry, name)
+		}
+	})
+}
+
+func TestHandler(t *testing.T) {
+	unregister(t, "test_matches_total", "test_seen_total", "test_query_seconds")
+	c := NewCounter("test_matches_total", "Matches.", "handler")
+	c.Inc("mtgsearch")
+	c.Add(2, "mtgstats")
+	NewCounter("test_seen_total", "Seen.")
+	h := NewHistogram("test_query_seconds", "Query time.", []float64{.1, 1})
+	h.Observe(.05)
+	h.Observe(.5)
+	h.Observe(2)
+	if h.Count() != 3 {
+		t.Errorf("expected 3 observations, got %d", h.Count())
+	}
+
+	w := httptest.NewRecorder()
+	Handler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	out := w.Body.String()
+	for _, want := range []string{
+		"# TYPE test_matches_total counter\n",
+		`test_matches_total{handler="mtgsearch"} 1` + "\n",
+		`test_matches_total{handler="mtgstats"} 2` + "\n",
+		"test_seen_total 0\n",
+		"# TYPE test_query_seconds histogram\n",
+		`test_query_seconds_bucket{le="0.1"} 1` + "\n",
+		`test_query_seconds_bucket{le="1"} 2` + "\n",
+		`test_query_seconds_bucket{le="+Inf"} 3` + "\n",
+		"test_query_seconds_sum 2.55\n",
+		"test_query_seconds_count 3\n",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("expected output to contain %q:\n%s", want, out)
+		}
+	}
+	if strings.Index(out, "test_matches_total") > strings.Index(out, "test_seen_total") {
+		t.Error("expected metrics sorted by name")
+	}
+}
+
+func TestLabelEscaping(t *testing.T) {
+	if got, want := quote("オラ\t\"ora\"\\\n"), `"オラ`+"\t"+`\"ora\"\\\n"`; got != want {
+		t.Errorf("expected %s, got %s", want, got)
+	}
+}
+
+func TestHealth(t *testing.T) {
+	t.Cleanup(func() {
+		checkMu.Lock()
+		defer checkMu.Unlock()
+		delete(checks, "database")
+		delete(checks, "transport")
+	})
+	Check("database", func(context.Context) error { return nil })
+	w := httptest.NewRecorder()
+	Health().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	if w.Code != http.StatusOK || w.Body.String() != "ok\n" {
+		t.Errorf("expected healthy, got %d %q", w.Code, w.Body.String())
+	}
+
+	Check("transport", func(context.Context) error { return errors.New("not connected") })
+	w = httptest.NewRecorder()
+	Health().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	if w.Code != http.StatusServiceUnavailable || !strings.Contains(w.Body.String(), "transport: not connected") {
+		t.Errorf("expected unhealthy transport, got %d %q", w.Code, w.Body.String())
+	}
+}
@@ -0,0 +1,146 @@
+package blockkit
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/komon/gosukebot/transport"
+	"github.com/komon/gosukebot/transport/outbox"
+	"github.com/nlopes/slack"
+)
+
+const (
+	// slack won't accept a message with more blocks than this
+	maxBlocks = 50
+	// MaxSectionText is the most text slack will show in a section block
+	MaxSectionText = 3000
+)
+
+// PickAction starts the action ID of each button offered when a search
+// matched several cards, the button's value is what to search for instead
+const PickAction = "pick"
+
+// Blocks renders card results as Slack Block Kit blocks: a section with
+// the name, cost, type line, oracle text and stats next to the card
+// image, then a context line with the set and rarity. Cards that could
+// have been any of several get a button for each when buttons is set,
+// which only makes sense when the transport receives the clicks, and a
+// list of lookups to try otherwise
+func Blocks(cards []transport.Card, buttons bool) []slack.Block {
+	var blocks []slack.Block
+	for i, c := range cards {
+		if i != 0 {
+			blocks = append(blocks, slack.NewDividerBlock())
+		}
+		blocks = append(blocks, cardBlocks(c, buttons)...)
+	}
+	if len(blocks) > maxBlocks {
+		blocks = blocks[:maxBlocks]
+	}
+	return blocks
+}
+
+// ResponseBlocks renders a response with cards as blocks: its notes from
+// other handlers first, in sections of their own, then the cards. A
+// response without cards is plain text and has no blocks
+func ResponseBlocks(resp transport.Response, buttons bool) []slack.Block {
+	if len(resp.Cards) == 0 {
+		return nil
+	}
+	var blocks []slack.Block
+	if strings.TrimSpace(resp.Notes) != "" {
+		for _, part := range outbox.Split(resp.Notes, MaxSectionText) {
+			blocks = append(blocks, slack.NewSectionBlock(mrkdwn(part), nil, nil))
+		}
+		blocks = append(blocks, slack.NewDividerBlock())
+	}
+	blocks = append(blocks, Blocks(resp.Cards, buttons)...)
+	if len(blocks) > maxBlocks {
+		blocks = blocks[:maxBlocks]
+	}
+	return blocks
+}
+
+// MsgOptions returns the message options for posting resp, with blocks
+// if it has cards and plain text otherwise. The text is always included
+// since it's what shows up in notifications
+func MsgOptions(resp transport.Response, buttons bool) []slack.MsgOption {
+	opts := []slack.MsgOption{slack.MsgOptionText(resp.Text, false)}
+	if blocks := ResponseBlocks(resp, buttons); len(blocks) != 0 {
+		opts = append(opts, slack.MsgOptionBlocks(blocks...))
+	}
+	return opts
+}
+
+func cardBlocks(c transport.Card, buttons bool) []slack.Block {
+	if len(c.Candidates) != 0 {
+		return candidateBlocks(c, buttons)
+	}
+	if !c.Found() {
+		return []slack.Block{
+			slack.NewSectionBlock(mrkdwn("Card Not Found! _"+c.Query+"_"), nil, nil),
+		}
+	}
+
+	lines := []string{"*" + c.Name + "*  " + c.Cost}
+	if c.Type != "" {
+		lines = append(lines, "_"+c.Type+"_")
+	}
+	if text := strings.TrimSpace(c.Text); text != "" {
+		lines = append(lines, ">"+strings.Replace(text, "\n", "\n>", -1))
+	}
+	if stats := c.Stats(); stats != "" {
+		lines = append(lines, "*"+stats+"*")
+	}
+
+	var accessory *slack.Accessory
+	if c.ImageURL != "" {
+		accessory = slack.NewAccessory(slack.NewImageBlockElement(c.ImageURL, c.Name))
+	}
+	blocks := []slack.Block{
+		slack.NewSectionBlock(mrkdwn(strings.Join(lines, "\n")), nil, accessory),
+	}
+
+	var printing []string
+	for _, s := range []string{c.Set, c.Rarity} {
+		if s != "" {
+			printing = append(printing, s)
+		}
+	}
+	if len(printing) != 0 {
+		blocks = append(blocks, slack.NewContextBlock("", mrkdwn(strings.Join(printing, " · "))))
+	}
+	return blocks
+}
+
+// candidateBlocks offers a button for each card c could have meant,
+// searching the same set as c did, or lists the lookups to try instead
+// if there are no buttons
+func candidateBlocks(c transport.Card, clickable bool) []slack.Block {
+	set := ""
+	if i := strings.Index(c.Query, "|"); i != -1 {
+		set = c.Query[i:]
+	}
+	if !clickable {
+		lookups := make([]string, len(c.Candidates))
+		for i, name := range c.Candidates {
+			lookups[i] = "[[" + name + set + "]]"
+		}
+		return []slack.Block{
+			slack.NewSectionBlock(mrkdwn("Did you mean "+strings.Join(lookups, ", ")+"? _"+c.Query+"_"), nil, nil),
+		}
+	}
+	var buttons []slack.BlockElement
+	for i, name := range c.Candidates {
+		text := slack.NewTextBlockObject(slack.PlainTextType, name, false, false)
+		buttons = append(buttons, slack.NewButtonBlockElement(fmt.Sprintf("%s-%d", PickAction, i), name+set, text))
+	}
+	return []slack.Block{
+		slack.NewSectionBlock(mrkdwn("Did you mean? _"+c.Query+"_"), nil, nil),
+		slack.NewActionBlock("", buttons...),
+	}
+}
+
+func mrkdwn(text string) *slack.TextBlockObject {
+	return slack.NewTextBlockObject(slack.MarkdownType, text, false, false)
+}
@@ -0,0 +1,97 @@
+package blockkit
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/komon/gosukebot/transport"
+)
+
+func TestBlocks(t *testing.T) {
+	blocks := Blocks([]transport.Card{
+		{
+			Query:     "Tarmogoyf",
+			Name:      "Tarmogoyf",
+			Cost:      ":1::gg:",
+			Type:      "Creature — Lhurgoyf",
+			Text:      "Tarmogoyf's power is equal to the number of card types among cards in all graveyards.",
+			Power:     "0",
+			Toughness: "1",
+			Set:       "FUT",
+			Rarity:    "Rare",
+			ImageURL:  "http://gatherer.wizards.com/Handlers/Image.ashx?multiverseid=136142&type=card",
+		},
+		{Query: "Lightning Blot"},
+	}, true)
+
+	if len(blocks) != 4 {
+		t.Fatalf("expected section, context, divider, section; got %d blocks", len(blocks))
+	}
+	b, err := json.Marshal(blocks)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := string(b)
+	for _, want := range []string{"*Tarmogoyf*  :1::gg:", "_Creature — Lhurgoyf_", "*0/1*",
+		"FUT · Rare", `"type":"image"`, "multiverseid=136142", "Card Not Found! _Lightning Blot_"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("expected blocks to contain %q: %s", want, out)
+		}
+	}
+}
+
+func TestCandidateBlocks(t *testing.T) {
+	cards := []transport.Card{{Query: "bolt|M10", Candidates: []string{"Lightning Bolt", "Bolt of Keranos"}}}
+	blocks := Blocks(cards, true)
+	if len(blocks) != 2 {
+		t.Fatalf("expected section, actions; got %d blocks", len(blocks))
+	}
+	b, err := json.Marshal(blocks)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := string(b)
+	for _, want := range []string{"Did you mean? _bolt|M10_", `"action_id":"pick-0"`, `"value":"Lightning Bolt|M10"`,
+		`"action_id":"pick-1"`, `"value":"Bolt of Keranos|M10"`} {
+		if !strings.Contains(out, want) {
+			t.Errorf("expected blocks to contain %q: %s", want, out)
+		}
+	}
+
+	blocks = Blocks(cards, false)
+	b, err = json.Marshal(blocks)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out = string(b)
+	if len(blocks) != 1 || strings.Contains(out, "pick-") ||
+		!strings.Contains(out, "Did you mean [[Lightning Bolt|M10]], [[Bolt of Keranos|M10]]? _bolt|M10_") {
+		t.Errorf("expected the choices as text without buttons: %s", out)
+	}
+}
+
+func TestResponseBlocks(t *testing.T) {
+	if blocks := ResponseBlocks(transport.Response{Text: "Hello to you too"}, true); blocks != nil {
+		t.Errorf("expected no blocks without cards, got %d", len(blocks))
+	}
+
+	blocks := ResponseBlocks(transport.Response{
+		Text:  "Count: 12\nHello to you too\nCard Not Found! Lightning Blot",
+		Notes: "Count: 12\nHello to you too",
+		Cards: []transport.Card{{Query: "Lightning Blot"}},
+	}, true)
+	if len(blocks) != 3 {
+		t.Fatalf("expected section, divider, section; got %d blocks", len(blocks))
+	}
+	b, err := json.Marshal(blocks)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := string(b)
+	for _, want := range []string{`Count: 12\nHello to you too`, "Card Not Found! _Lightning Blot_"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("expected blocks to contain %q: %s", want, out)
+		}
+	}
+}
@@ -0,0 +1,43 @@
+package transport
+
+import "strings"
+
+// Card is a single card search result. A Response has one Card for every
+// card that was searched for, in order, and cards that weren't found have
+// nothing but their Query set, and their Candidates if there were any
+type Card struct {
+	// Query is what was searched for
+	Query string
+	// Candidates are the names the query could have meant, when it
+	// matched several cards and none of them exactly. The card isn't
+	// found then, it's up to the user to pick one
+	Candidates []string
+
+	Name      string
+	Cost      string
+	Type      string
+	Text      string
+	Power     string
+	Toughness string
+	Loyalty   string
+	Set       string
+	Rarity    string
+	ImageURL  string
+}
+
+// Found reports whether the search turned up a card
+func (c Card) Found() bool {
+	return c.Name != ""
+}
+
+// Stats returns power/toughness for creatures and starting loyalty for
+// planeswalkers, and nothing for anything else
+func (c Card) Stats() string {
+	switch {
+	case strings.Contains(c.Type, "Creature"):
+		return c.Power + "/" + c.Toughness
+	case strings.Contains(c.Type, "Planeswalker"):
+		return "Loyalty: " + c.Loyalty
+	}
+	return ""
+}
@@ -0,0 +1,282 @@
+package discord
+
+import (
+	"errors"
+	"log/slog"
+	"regexp"
+	"strings"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/komon/gosukebot/transport"
+)
+
+const (
+	// discord rejects messages with more embeds than this, or embeds with
+	// longer descriptions
+	maxEmbeds           = 10
+	maxDescriptionChars = 4096
+)
+
+// imageURL matches the gatherer card image links mtgsearch and mtgstats
+// put in their responses
+var imageURL = regexp.MustCompile(`https?://gatherer\.wizards\.com/Handlers/Image\.ashx\?\S+`)
+
+// Transport satisfies the transport.Transport interface over the Discord
+// gateway websocket, replies are sent through the REST api as embeds
+type Transport struct {
+	session  *discordgo.Session
+	logger   *slog.Logger
+	messages chan transport.Message
+	done     chan struct{}
+	sent     *transport.Tracker
+}
+
+// New returns a new Transport that will connect with the given bot token
+func New(token string, logger *slog.Logger) (*Transport, error) {
+	session, err := discordgo.New("Bot " + token)
+	if err != nil {
+		return nil, err
+	}
+	session.Identify.Intents = discordgo.IntentsGuildMessages |
+		discordgo.IntentsDirectMessages |
+		discordgo.IntentsMessageContent
+
+	t := &Transport{
+		session:  session,
+		logger:   logger,
+		messages: make(chan transport.Message),
+		done:     make(chan struct{}),
+		sent:     transport.NewTracker(1000),
+	}
+	session.AddHandler(t.messageCreate)
+	session.AddHandler(t.messageUpdate)
+	session.AddHandler(t.messageDelete)
+	return t, nil
+}
+
+// Connect opens the gateway connection, discordgo takes care of
+// heartbeats and reconnecting from there
+func (t *Transport) Connect() error {
+	return t.session.Open()
+}
+
+// Check satisfies transport.Checker, the session is ready once discord
+// has sent the initial state and stops being ready when it disconnects
+func (t *Transport) Check() error {
+	t.session.RLock()
+	defer t.session.RUnlock()
+	if !t.session.DataReady {
+		return errors.New("not connected")
+	}
+	return nil
+}
+
+// Messages returns the channel incoming messages are delivered on
+func (t *Transport) Messages() <-chan transport.Message {
+	return t.messages
+}
+
+// Send posts a reply to the channel it's addressed to, rendered as embeds.
+// Bots can't send ephemeral messages outside of interactions, so those
+// are posted like any other
+func (t *Transport) Send(r transport.Reply) error {
+	m, err := t.session.ChannelMessageSendComplex(r.Channel, &discordgo.MessageSend{
+		Embeds: replyEmbeds(r),
+	})
+	if err != nil {
+		return err
+	}
+	if r.Revisable {
+		t.sent.Add(r.Channel, r.Source, m.ID)
+	}
+	return nil
+}
+
+// Edit changes the reply to r.Source to r, or sends r if there wasn't one
+func (t *Transport) Edit(r transport.Reply) error {
+	ids := t.sent.Take(r.Channel, r.Source)
+	if len(ids) == 0 {
+		return t.Send(r)
+	}
+	edit := discordgo.NewMessageEdit(r.Channel, ids[0])
+	edit.Embeds = replyEmbeds(r)
+	_, err := t.session.ChannelMessageEditComplex(edit)
+	for _, id := range ids {
+		t.sent.Add(r.Channel, r.Source, id)
+	}
+	return err
+}
+
+// Delete removes the reply to source
+func (t *Transport) Delete(channel, source string) error {
+	for _, id := range t.sent.Take(channel, source) {
+		if err := t.session.ChannelMessageDelete(channel, id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// replyEmbeds renders r, with any notes from other handlers ahead of its
+// cards
+func replyEmbeds(r transport.Reply) []*discordgo.MessageEmbed {
+	if len(r.Cards) == 0 {
+		return embeds(r.Text)
+	}
+	es := append(embeds(r.Notes), cardEmbeds(r.Cards)...)
+	if len(es) > maxEmbeds {
+		es = es[:maxEmbeds]
+	}
+	return es
+}
+
+// Close closes the gateway connection
+func (t *Transport) Close() error {
+	close(t.done)
+	return t.session.Close()
+}
+
+func (t *Transport) messageCreate(s *discordgo.Session, m *discordgo.MessageCreate) {
+	if m.Author == nil || (s.State.User != nil && m.Author.ID == s.State.User.ID) {
+		return
+	}
+	t.deliver(transport.Message{
+		Text:        m.Content,
+		User:        m.Author.ID,
+		UserName:    userName(m.Message),
+		Channel:     m.ChannelID,
+		ChannelName: channelName(s, m.ChannelID),
+		Timestamp:   m.ID,
+		Bot:         m.Author.Bot,
+	})
+}
+
+// messageUpdate follows edits to messages, discord also sends updates
+// when embeds are added to a message, those don't have an author
+func (t *Transport) messageUpdate(s *discordgo.Session, m *discordgo.MessageUpdate) {
+	if m.Author == nil || (s.State.User != nil && m.Author.ID == s.State.User.ID) {
+		return
+	}
+	if m.BeforeUpdate != nil && m.BeforeUpdate.Content == m.Content {
+		return
+	}
+	t.deliver(transport.Message{
+		Text:        m.Content,
+		User:        m.Author.ID,
+		UserName:    userName(m.Message),
+		Channel:     m.ChannelID,
+		ChannelName: channelName(s, m.ChannelID),
+		Timestamp:   m.ID,
+		Edited:      true,
+		Bot:         m.Author.Bot,
+	})
+}
+
+// userName is the author's nickname in the server if they have one, or
+// their username
+func userName(m *discordgo.Message) string {
+	if m.Member != nil && m.Member.Nick != "" {
+		return m.Member.Nick
+	}
+	return m.Author.Username
+}
+
+// channelName looks the channel up in the session's state, DMs don't
+// have names
+func channelName(s *discordgo.Session, id string) string {
+	if ch, err := s.State.Channel(id); err == nil {
+		return ch.Name
+	}
+	return ""
+}
+
+func (t *Transport) messageDelete(s *discordgo.Session, m *discordgo.MessageDelete) {
+	t.deliver(transport.Message{
+		Channel:   m.ChannelID,
+		Timestamp: m.ID,
+		Deleted:   true,
+	})
+}
+
+func (t *Transport) deliver(msg transport.Message) {
+	select {
+	case t.messages <- msg:
+	case <-t.done:
+	}
+}
+
+// embeds renders a handler response as Discord embeds. Each line with a
+// card image link in it gets an embed of its own with the link as the
+// embed image, runs of other lines are collected into plain embeds
+func embeds(text string) []*discordgo.MessageEmbed {
+	var (
+		es      []*discordgo.MessageEmbed
+		pending []string
+	)
+	flush := func() {
+		if desc := strings.TrimSpace(strings.Join(pending, "\n")); desc != "" {
+			es = append(es, &discordgo.MessageEmbed{Description: truncate(desc)})
+		}
+		pending = nil
+	}
+
+	for _, line := range strings.Split(text, "\n") {
+		url := imageURL.FindString(line)
+		if url == "" {
+			pending = append(pending, line)
+			continue
+		}
+		flush()
+		desc := strings.Join(strings.Fields(strings.Replace(line, url, "", 1)), " ")
+		es = append(es, &discordgo.MessageEmbed{
+			Description: truncate(desc),
+			Image:       &discordgo.MessageEmbedImage{URL: url},
+		})
+	}
+	flush()
+
+	if len(es) > maxEmbeds {
+		es = es[:maxEmbeds]
+	}
+	return es
+}
+
+// cardEmbeds renders card results as one embed per card, with the oracle
+// text as the description and the card image underneath
+func cardEmbeds(cards []transport.Card) []*discordgo.MessageEmbed {
+	var es []*discordgo.MessageEmbed
+	for _, c := range cards {
+		if len(c.Candidates) != 0 {
+			es = append(es, &discordgo.MessageEmbed{Description: "Did you mean **" + strings.Join(c.Candidates, "**, **") + "**? *" + c.Query + "*"})
+			continue
+		}
+		if !c.Found() {
+			es = append(es, &discordgo.MessageEmbed{Description: "Card Not Found! *" + c.Query + "*"})
+			continue
+		}
+		e := &discordgo.MessageEmbed{
+			Title:       strings.TrimSpace(c.Name + " " + c.Cost),
+			Description: truncate(c.Text),
+		}
+		for _, f := range [][2]string{{"Type", c.Type}, {"Stats", c.Stats()}, {"Set", c.Set}, {"Rarity", c.Rarity}} {
+			if f[1] != "" {
+				e.Fields = append(e.Fields, &discordgo.MessageEmbedField{Name: f[0], Value: f[1], Inline: true})
+			}
+		}
+		if c.ImageURL != "" {
+			e.Image = &discordgo.MessageEmbedImage{URL: c.ImageURL}
+		}
+		es = append(es, e)
+	}
+	if len(es) > maxEmbeds {
+		es = es[:maxEmbeds]
+	}
+	return es
+}
+
+func truncate(s string) string {
+	if r := []rune(s); len(r) > maxDescriptionChars {
+		return string(r[:maxDescriptionChars-1]) + "…"
+	}
+	return s
+}
@@ -0,0 +1,76 @@
+package discord
+
+import (
+	"testing"
+
+	"github.com/komon/gosukebot/transport"
+)
+
+func TestEmbeds(t *testing.T) {
+	es := embeds("http://gatherer.wizards.com/Handlers/Image.ashx?multiverseid=397722&type=card " +
+		"{R} ```Lightning Bolt deals 3 damage to any target.``` M11")
+	if len(es) != 1 {
+		t.Fatalf("expected 1 embed, got %d", len(es))
+	}
+	if es[0].Image == nil || es[0].Image.URL != "http://gatherer.wizards.com/Handlers/Image.ashx?multiverseid=397722&type=card" {
+		t.Errorf("expected card image, got %+v", es[0].Image)
+	}
+	if es[0].Description != "{R} ```Lightning Bolt deals 3 damage to any target.``` M11" {
+		t.Errorf("unexpected description %q", es[0].Description)
+	}
+
+	es = embeds("Count: 12\n" +
+		"Minimum cmc: Ornithopter http://gatherer.wizards.com/Handlers/Image.ashx?multiverseid=1&type=card\n" +
+		"Maximum cmc: Draco http://gatherer.wizards.com/Handlers/Image.ashx?multiverseid=2&type=card\n")
+	if len(es) != 3 {
+		t.Fatalf("expected 3 embeds, got %d", len(es))
+	}
+	if es[0].Image != nil || es[0].Description != "Count: 12" {
+		t.Errorf("unexpected text embed %+v", es[0])
+	}
+	if es[2].Description != "Maximum cmc: Draco" || es[2].Image == nil {
+		t.Errorf("unexpected image embed %+v", es[2])
+	}
+}
+
+func TestCardEmbeds(t *testing.T) {
+	es := cardEmbeds([]transport.Card{
+		{
+			Query:    "Lightning Bolt",
+			Name:     "Lightning Bolt",
+			Cost:     ":rr:",
+			Type:     "Instant",
+			Text:     "Lightning Bolt deals 3 damage to any target.",
+			Set:      "M11",
+			Rarity:   "Common",
+			ImageURL: "http://gatherer.wizards.com/Handlers/Image.ashx?multiverseid=1&type=card",
+		},
+		{Query: "Lightning Blot"},
+	})
+	if len(es) != 2 {
+		t.Fatalf("expected 2 embeds, got %d", len(es))
+	}
+	if es[0].Title != "Lightning Bolt :rr:" || es[0].Image == nil || len(es[0].Fields) != 3 {
+		t.Errorf("unexpected card embed %+v", es[0])
+	}
+	if es[1].Description != "Card Not Found! *Lightning Blot*" {
+		t.Errorf("unexpected not found embed %+v", es[1])
+	}
+}
+
+func TestReplyEmbeds(t *testing.T) {
+	es := replyEmbeds(transport.Reply{Response: transport.Response{
+		Text:  "Count: 12\nHello to you too\nCard Not Found! Lightning Blot",
+		Notes: "Count: 12\nHello to you too",
+		Cards: []transport.Card{{Query: "Lightning Blot"}},
+	}})
+	if len(es) != 2 {
+		t.Fatalf("expected the notes and the card, got %d embeds", len(es))
+	}
+	if es[0].Description != "Count: 12\nHello to you too" {
+		t.Errorf("unexpected notes embed %+v", es[0])
+	}
+	if es[1].Description != "Card Not Found! *Lightning Blot*" {
+		t.Errorf("unexpected card embed %+v", es[1])
+	}
+}
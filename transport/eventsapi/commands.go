@@ -0,0 +1,203 @@
+package eventsapi
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/komon/gosukebot/transport"
+	"github.com/komon/gosukebot/transport/blockkit"
+	"github.com/nlopes/slack"
+)
+
+const (
+	// shareAction is the button under a slash command's answer that posts
+	// it to the channel
+	shareAction = "share"
+	// slack stops taking answers on a response URL after half an hour
+	responseURLExpiry = 30 * time.Minute
+)
+
+// pending is a slash command or button click waiting to be answered
+type pending struct {
+	url string
+	// line is what was asked, a command line starting with a slash or
+	// message text, for asking again when the answer is shared
+	line string
+	// public is set when the answer goes to the whole channel
+	public bool
+	// replace is set when the answer replaces the message with the button
+	// that was clicked
+	replace bool
+	at      time.Time
+}
+
+// response is what's posted to a response URL
+type response struct {
+	Text            string        `json:"text,omitempty"`
+	ResponseType    string        `json:"response_type,omitempty"`
+	Blocks          []slack.Block `json:"blocks,omitempty"`
+	ReplaceOriginal bool          `json:"replace_original,omitempty"`
+	DeleteOriginal  bool          `json:"delete_original,omitempty"`
+}
+
+// container is the part of an interaction payload saying where the
+// clicked button was, which slack's InteractionCallback leaves out
+type container struct {
+	Container struct {
+		IsEphemeral bool `json:"is_ephemeral"`
+	} `json:"container"`
+}
+
+// command delivers a slash command, its answer goes back through its
+// response URL. The trigger ID stands in for the message timestamp, it's
+// the only thing identifying a command
+func (t *HTTPTransport) command(form url.Values) {
+	msg := transport.Message{
+		Text:      form.Get("text"),
+		User:      form.Get("user_id"),
+		Channel:   form.Get("channel_id"),
+		Timestamp: form.Get("trigger_id"),
+		Command:   strings.TrimPrefix(form.Get("command"), "/"),
+	}
+	t.wait(msg, pending{url: form.Get("response_url")})
+	t.deliver(msg)
+}
+
+// interaction handles a button click, whether it came over HTTP or Socket
+// Mode. Share buttons delete the answer only the user could see and ask
+// again, with the answer going to the whole channel this time. Pick
+// buttons, offered when a card search was ambiguous, search for the card
+// picked and replace the message with it
+func (b *base) interaction(payload string) {
+	var (
+		cb    slack.InteractionCallback
+		where container
+	)
+	if err := json.Unmarshal([]byte(payload), &cb); err != nil {
+		b.logger.Warn("bad interaction payload", "err", err)
+		return
+	}
+	json.Unmarshal([]byte(payload), &where)
+	if cb.Type != slack.InteractionTypeBlockActions {
+		return
+	}
+	for _, action := range cb.ActionCallback.BlockActions {
+		msg := transport.Message{
+			User:      cb.User.ID,
+			Channel:   cb.Channel.ID,
+			Timestamp: cb.TriggerID,
+		}
+		p := pending{url: cb.ResponseURL}
+		switch {
+		case action.ActionID == shareAction:
+			if err := b.respond(cb.ResponseURL, response{DeleteOriginal: true}); err != nil {
+				b.logger.Warn("couldn't delete shared answer", "err", err)
+			}
+			msg.Command, msg.Text = parse(action.Value)
+			p.public = true
+		case strings.HasPrefix(action.ActionID, blockkit.PickAction):
+			msg.Text = "[[" + action.Value + "]]"
+			p.public = !where.Container.IsEphemeral
+			p.replace = true
+		default:
+			continue
+		}
+		b.wait(msg, p)
+		b.deliver(msg)
+	}
+}
+
+// wait remembers where to send the answer to msg, forgetting anything too
+// old to answer any more
+func (b *base) wait(msg transport.Message, p pending) {
+	now := time.Now()
+	b.cmu.Lock()
+	defer b.cmu.Unlock()
+	for key, p := range b.pending {
+		if now.Sub(p.at) > responseURLExpiry {
+			delete(b.pending, key)
+		}
+	}
+	p.line, p.at = msg.Text, now
+	if msg.Command != "" {
+		p.line = "/" + msg.Command + " " + msg.Text
+	}
+	b.pending[msg.Channel+"\x00"+msg.Timestamp] = p
+}
+
+// Send answers slash commands and button clicks through their response
+// URL, everything else is posted as usual
+func (b *base) Send(r transport.Reply) error {
+	b.cmu.Lock()
+	p, ok := b.pending[r.Channel+"\x00"+r.Source]
+	b.cmu.Unlock()
+	if !ok {
+		return b.Poster.Send(r)
+	}
+	return b.respond(p.url, answer(r, p))
+}
+
+// answer renders r for a response URL. Unless it's public, it's only
+// shown to the user who asked, with a button to post it to the channel.
+// Notices like being rate limited can't be shared
+func answer(r transport.Reply, p pending) response {
+	resp := response{Text: r.Text, ResponseType: slack.ResponseTypeInChannel, ReplaceOriginal: p.replace}
+	blocks := blockkit.ResponseBlocks(r.Response, true)
+	if p.public {
+		resp.Blocks = blocks
+		return resp
+	}
+	resp.ResponseType = slack.ResponseTypeEphemeral
+	if r.Ephemeral {
+		return resp
+	}
+	if len(blocks) == 0 {
+		if len(r.Text) > blockkit.MaxSectionText {
+			return resp
+		}
+		blocks = append(blocks, slack.NewSectionBlock(
+			slack.NewTextBlockObject(slack.MarkdownType, r.Text, false, false), nil, nil))
+	}
+	button := slack.NewButtonBlockElement(shareAction, p.line,
+		slack.NewTextBlockObject(slack.PlainTextType, "Post to channel", false, false))
+	resp.Blocks = append(blocks, slack.NewActionBlock("", button))
+	return resp
+}
+
+// respond posts resp to a response URL
+func (b *base) respond(responseURL string, resp response) error {
+	body, err := json.Marshal(resp)
+	if err != nil {
+		return err
+	}
+	res, err := b.client.Post(responseURL, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode == http.StatusTooManyRequests {
+		return &transport.RateLimitError{}
+	}
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("response url: %s", res.Status)
+	}
+	return nil
+}
+
+// parse splits a command line into the command and its text, anything
+// not starting with a slash is just text
+func parse(line string) (command, text string) {
+	if !strings.HasPrefix(line, "/") {
+		return "", line
+	}
+	parts := strings.SplitN(line[1:], " ", 2)
+	if len(parts) == 1 {
+		return parts[0], ""
+	}
+	return parts[0], parts[1]
+}
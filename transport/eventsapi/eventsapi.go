@@ -0,0 +1,177 @@
+package eventsapi
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/komon/gosukebot/logging"
+	"github.com/komon/gosukebot/transport"
+	"github.com/komon/gosukebot/transport/slackweb"
+	"github.com/nlopes/slack"
+	"github.com/nlopes/slack/slackevents"
+)
+
+// Option configures an HTTPTransport or SocketModeTransport
+type Option func(*base)
+
+// OptionAPIURL points the transport at a different Slack web API, it's
+// only really useful for testing against a fake server
+func OptionAPIURL(url string) Option {
+	return func(b *base) {
+		b.apiURL = url
+	}
+}
+
+// base is the part shared by both transports: they only differ in how
+// events arrive, replies always go out through the web API
+type base struct {
+	*slackweb.Poster
+	apiURL   string
+	logger   *slog.Logger
+	messages chan transport.Message
+	done     chan struct{}
+
+	mu       sync.RWMutex
+	closed   bool
+	inflight sync.WaitGroup
+
+	// connected is whether events can reach us right now
+	connected atomic.Bool
+
+	// pending holds the slash commands and button clicks waiting to be
+	// answered, by channel and trigger ID
+	client  *http.Client
+	cmu     sync.Mutex
+	pending map[string]pending
+}
+
+func newBase(botToken string, logger *slog.Logger, opts []Option) *base {
+	b := &base{
+		apiURL:   slack.APIURL,
+		logger:   logger,
+		messages: make(chan transport.Message),
+		done:     make(chan struct{}),
+		client:   &http.Client{Timeout: 10 * time.Second},
+		pending:  map[string]pending{},
+	}
+	for _, opt := range opts {
+		opt(b)
+	}
+	b.Poster = slackweb.New(slack.New(botToken, slack.OptionLog(logging.Std(logger, slog.LevelInfo)), slack.OptionAPIURL(b.apiURL)))
+	// button clicks come back to both transports, unlike over rtm
+	b.EnableButtons()
+	return b
+}
+
+// Check satisfies transport.Checker
+func (b *base) Check() error {
+	if !b.connected.Load() {
+		return errors.New("not connected")
+	}
+	return nil
+}
+
+// Messages returns the channel incoming messages are delivered on
+func (b *base) Messages() <-chan transport.Message {
+	return b.messages
+}
+
+// shutdown stops delivering messages, waiting for any deliveries already
+// underway so the Messages channel can be closed safely
+func (b *base) shutdown() {
+	b.mu.Lock()
+	b.closed = true
+	close(b.done)
+	b.mu.Unlock()
+	b.inflight.Wait()
+	close(b.messages)
+}
+
+// dispatch delivers the message in a parsed event callback, if there is
+// one, blocking until it's read or the transport is closed
+func (b *base) dispatch(event slackevents.EventsAPIEvent) {
+	if event.Type != slackevents.CallbackEvent {
+		return
+	}
+	ev, ok := event.InnerEvent.Data.(*slackevents.MessageEvent)
+	if !ok {
+		return
+	}
+	msg, ok := message(ev)
+	if !ok || b.Self(msg.User) {
+		return
+	}
+	b.deliver(msg)
+}
+
+// deliver hands msg to the bot, blocking until it's read or the transport
+// is closed
+func (b *base) deliver(msg transport.Message) {
+	b.mu.RLock()
+	if b.closed {
+		b.mu.RUnlock()
+		return
+	}
+	b.inflight.Add(1)
+	b.mu.RUnlock()
+	defer b.inflight.Done()
+
+	select {
+	case b.messages <- msg:
+	case <-b.done:
+	}
+}
+
+// message converts a message event, following edits and deletes of
+// earlier messages. Edits that don't change the text, like link previews
+// being added, are skipped, as are subtypes that aren't somebody saying
+// something. Messages from bots are marked, and any without a user get
+// the bot's ID instead
+func message(ev *slackevents.MessageEvent) (transport.Message, bool) {
+	if !slackweb.Said(ev.SubType) {
+		return transport.Message{}, false
+	}
+	switch ev.SubType {
+	case "message_changed":
+		if ev.Message == nil || ev.PreviousMessage != nil && ev.PreviousMessage.Text == ev.Message.Text {
+			return transport.Message{}, false
+		}
+		return transport.Message{
+			Text:      ev.Message.Text,
+			User:      user(ev.Message.User, ev.Message.BotID),
+			Channel:   ev.Channel,
+			Thread:    ev.Message.ThreadTimeStamp,
+			Timestamp: ev.Message.TimeStamp,
+			Edited:    true,
+			Bot:       ev.Message.BotID != "",
+		}, true
+	case "message_deleted":
+		if ev.PreviousMessage == nil {
+			return transport.Message{}, false
+		}
+		return transport.Message{
+			Channel:   ev.Channel,
+			Timestamp: ev.PreviousMessage.TimeStamp,
+			Deleted:   true,
+		}, true
+	}
+	return transport.Message{
+		Text:      ev.Text,
+		User:      user(ev.User, ev.BotID),
+		Channel:   ev.Channel,
+		Thread:    ev.ThreadTimeStamp,
+		Timestamp: ev.TimeStamp,
+		Bot:       ev.BotID != "" || ev.SubType == "bot_message",
+	}, true
+}
+
+func user(user, bot string) string {
+	if user == "" {
+		return bot
+	}
+	return user
+}
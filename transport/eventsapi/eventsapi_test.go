@@ -0,0 +1,497 @@
+package eventsapi
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/komon/gosukebot/logging"
+	"github.com/komon/gosukebot/transport"
+	"github.com/nlopes/slack/slackevents"
+)
+
+const (
+	testSecret      = "8f742231b10e8888abcd99yyyzzz85a5"
+	messageCallback = `{
+		"token": "XXYYZZ",
+		"team_id": "TXXXXXXXX",
+		"type": "event_callback",
+		"event": {
+			"type": "message",
+			"user": "U2147483697",
+			"text": "[[Lightning Bolt]]",
+			"ts": "1355517523.000005",
+			"thread_ts": "1355517500.000001",
+			"channel": "C2147483705"
+		}
+	}`
+)
+
+var testLogger = logging.Discard()
+
+func signedRequest(body, secret string) *http.Request {
+	ts := strconv.FormatInt(time.Now().Unix(), 10)
+	mac := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(mac, "v0:%s:%s", ts, body)
+
+	r := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body))
+	r.Header.Set("X-Slack-Request-Timestamp", ts)
+	r.Header.Set("X-Slack-Signature", "v0="+hex.EncodeToString(mac.Sum(nil)))
+	return r
+}
+
+func receive(t *testing.T, msgs <-chan transport.Message) transport.Message {
+	t.Helper()
+	select {
+	case msg := <-msgs:
+		return msg
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for message")
+	}
+	return transport.Message{}
+}
+
+func TestHTTPDeliversSignedMessages(t *testing.T) {
+	slack := newFakeSlack(t)
+	defer slack.Close()
+
+	tr := NewHTTP("", testSecret, "xoxb-test", testLogger, OptionAPIURL(slack.URL+"/"))
+	defer tr.Close()
+
+	for i := 0; i < 2; i++ {
+		w := httptest.NewRecorder()
+		tr.ServeHTTP(w, signedRequest(messageCallback, testSecret))
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected 200, got %d", w.Code)
+		}
+
+		msg := receive(t, tr.Messages())
+		if msg.UserName != "" || slack.lookups.Load() != int32(2*i) {
+			t.Errorf("expected names to be left for the worker to look up, got %+v", msg)
+		}
+		tr.Name(&msg)
+		want := transport.Message{
+			Text:        "[[Lightning Bolt]]",
+			User:        "U2147483697",
+			UserName:    "Jotaro",
+			Channel:     "C2147483705",
+			ChannelName: "general",
+			Thread:      "1355517500.000001",
+			Timestamp:   "1355517523.000005",
+		}
+		if msg != want {
+			t.Errorf("expected %+v, got %+v", want, msg)
+		}
+	}
+	if n := slack.lookups.Load(); n != 2 {
+		t.Errorf("expected the names to be looked up once and cached, got %d lookups", n)
+	}
+}
+
+func TestHTTPKeepsOrder(t *testing.T) {
+	tr := NewHTTP("", testSecret, "xoxb-test", testLogger)
+	defer tr.Close()
+
+	for i := 0; i < 10; i++ {
+		body := strings.Replace(messageCallback, "[[Lightning Bolt]]", strconv.Itoa(i), 1)
+		w := httptest.NewRecorder()
+		tr.ServeHTTP(w, signedRequest(body, testSecret))
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected 200, got %d", w.Code)
+		}
+	}
+	for i := 0; i < 10; i++ {
+		if msg := receive(t, tr.Messages()); msg.Text != strconv.Itoa(i) {
+			t.Errorf("message %d out of order: %q", i, msg.Text)
+		}
+	}
+}
+
+func TestHTTPRejectsBadSignatures(t *testing.T) {
+	tr := NewHTTP("", testSecret, "xoxb-test", testLogger)
+	defer tr.Close()
+
+	w := httptest.NewRecorder()
+	tr.ServeHTTP(w, signedRequest(messageCallback, "not the secret"))
+	if w.Code != http.StatusUnauthorized {
+		t.Fatalf("expected 401, got %d", w.Code)
+	}
+
+	w = httptest.NewRecorder()
+	tr.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/", strings.NewReader(messageCallback)))
+	if w.Code != http.StatusUnauthorized {
+		t.Fatalf("expected 401 for unsigned request, got %d", w.Code)
+	}
+}
+
+func TestHTTPNeedsSigningSecret(t *testing.T) {
+	tr := NewHTTP("127.0.0.1:0", "", "xoxb-test", testLogger)
+	defer tr.Close()
+	if err := tr.Connect(); err != errNoSecret {
+		t.Errorf("expected connecting without a signing secret to fail, got %v", err)
+	}
+
+	w := httptest.NewRecorder()
+	tr.ServeHTTP(w, signedRequest(messageCallback, ""))
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("expected a request signed with no secret to get 401, got %d", w.Code)
+	}
+}
+
+func TestHTTPAnswersURLVerification(t *testing.T) {
+	tr := NewHTTP("", testSecret, "xoxb-test", testLogger)
+	defer tr.Close()
+
+	body := `{"token": "XXYYZZ", "challenge": "3eZbrw1aBm2rZgRNFdxV2595E9CY3gmdALWMmHkvFXO7tYXAYM8P", "type": "url_verification"}`
+	w := httptest.NewRecorder()
+	tr.ServeHTTP(w, signedRequest(body, testSecret))
+	if got := w.Body.String(); got != "3eZbrw1aBm2rZgRNFdxV2595E9CY3gmdALWMmHkvFXO7tYXAYM8P" {
+		t.Errorf("unexpected challenge response %q", got)
+	}
+}
+
+// fakeSlack serves the bits of the web API and Socket Mode the transports
+// use, recording posted messages and acknowledged envelopes
+type fakeSlack struct {
+	*httptest.Server
+	posted    chan url.Values
+	acked     chan string
+	responded chan map[string]interface{}
+	lookups   atomic.Int32
+	// envelope is what Socket Mode sends once connected
+	envelope string
+}
+
+func newFakeSlack(t *testing.T) *fakeSlack {
+	f := &fakeSlack{
+		posted:    make(chan url.Values, 1),
+		acked:     make(chan string, 1),
+		responded: make(chan map[string]interface{}, 1),
+		envelope:  `{"type": "events_api", "envelope_id": "57d6a792-4d35-4d0b-b6aa-3361493e1caf", "payload": ` + messageCallback + `}`,
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/chat.postMessage", func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		f.posted <- r.PostForm
+		fmt.Fprint(w, `{"ok": true, "channel": "C2147483705", "ts": "1355517524.000001"}`)
+	})
+	mux.HandleFunc("/chat.postEphemeral", func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		f.posted <- r.PostForm
+		fmt.Fprint(w, `{"ok": true, "message_ts": "1355517524.000002"}`)
+	})
+	mux.HandleFunc("/respond", func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]interface{}
+		json.NewDecoder(r.Body).Decode(&body)
+		f.responded <- body
+	})
+	mux.HandleFunc("/users.info", func(w http.ResponseWriter, r *http.Request) {
+		f.lookups.Add(1)
+		fmt.Fprint(w, `{"ok": true, "user": {"id": "U2147483697", "name": "jotaro", "real_name": "Jotaro Kujo", "profile": {"display_name": "Jotaro"}}}`)
+	})
+	mux.HandleFunc("/conversations.info", func(w http.ResponseWriter, r *http.Request) {
+		f.lookups.Add(1)
+		fmt.Fprint(w, `{"ok": true, "channel": {"id": "C2147483705", "name": "general"}}`)
+	})
+	mux.HandleFunc("/auth.test", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"ok": true, "user_id": "U0BOT"}`)
+	})
+	mux.HandleFunc("/apps.connections.open", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer xapp-test" {
+			fmt.Fprint(w, `{"ok": false, "error": "invalid_auth"}`)
+			return
+		}
+		fmt.Fprintf(w, `{"ok": true, "url": "ws%s/link"}`, strings.TrimPrefix(f.URL, "http"))
+	})
+	mux.HandleFunc("/link", func(w http.ResponseWriter, r *http.Request) {
+		conn, err := (&websocket.Upgrader{}).Upgrade(w, r, nil)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		defer conn.Close()
+		conn.WriteJSON(map[string]string{"type": "hello"})
+		conn.WriteMessage(websocket.TextMessage, []byte(f.envelope))
+		var ack struct {
+			EnvelopeID string `json:"envelope_id"`
+		}
+		if err := conn.ReadJSON(&ack); err == nil {
+			f.acked <- ack.EnvelopeID
+		}
+		conn.ReadMessage()
+	})
+	f.Server = httptest.NewServer(mux)
+	return f
+}
+
+func TestSocketModeDeliversAndAcknowledges(t *testing.T) {
+	slack := newFakeSlack(t)
+	defer slack.Close()
+
+	tr := NewSocketMode("xapp-test", "xoxb-test", testLogger, OptionAPIURL(slack.URL+"/"))
+	if err := tr.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	defer tr.Close()
+
+	if msg := receive(t, tr.Messages()); msg.Text != "[[Lightning Bolt]]" {
+		t.Errorf("unexpected message %+v", msg)
+	}
+	select {
+	case id := <-slack.acked:
+		if id != "57d6a792-4d35-4d0b-b6aa-3361493e1caf" {
+			t.Errorf("acknowledged wrong envelope %q", id)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("envelope was never acknowledged")
+	}
+}
+
+func TestSocketModeBadAppToken(t *testing.T) {
+	slack := newFakeSlack(t)
+	defer slack.Close()
+
+	tr := NewSocketMode("xapp-wrong", "xoxb-test", testLogger, OptionAPIURL(slack.URL+"/"))
+	if err := tr.Connect(); err == nil {
+		t.Error("expected connect to fail with a bad app token")
+	}
+}
+
+func TestSendPostsToThread(t *testing.T) {
+	slack := newFakeSlack(t)
+	defer slack.Close()
+
+	tr := NewHTTP("", testSecret, "xoxb-test", testLogger, OptionAPIURL(slack.URL+"/"))
+	defer tr.Close()
+
+	err := tr.Send(transport.Reply{Response: transport.Response{Text: "Card Not Found!"}, Channel: "C2147483705", Thread: "1355517500.000001"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	posted := <-slack.posted
+	if posted.Get("channel") != "C2147483705" || posted.Get("thread_ts") != "1355517500.000001" ||
+		posted.Get("text") != "Card Not Found!" {
+		t.Errorf("unexpected post %v", posted)
+	}
+}
+
+func TestSendEphemeral(t *testing.T) {
+	slack := newFakeSlack(t)
+	defer slack.Close()
+
+	tr := NewHTTP("", testSecret, "xoxb-test", testLogger, OptionAPIURL(slack.URL+"/"))
+	defer tr.Close()
+
+	err := tr.Send(transport.Reply{Response: transport.Response{Text: "Slow down", Ephemeral: true},
+		Channel: "C2147483705", User: "U2147483697"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	posted := <-slack.posted
+	if posted.Get("channel") != "C2147483705" || posted.Get("user") != "U2147483697" {
+		t.Errorf("unexpected ephemeral post %v", posted)
+	}
+}
+
+func TestMessageFollowsEdits(t *testing.T) {
+	edited, ok := message(&slackevents.MessageEvent{
+		SubType:         "message_changed",
+		Channel:         "C2147483705",
+		Message:         &slackevents.MessageEvent{Text: "[[Lightning Bolt]]", User: "U2147483697", TimeStamp: "1355517523.000005"},
+		PreviousMessage: &slackevents.MessageEvent{Text: "[[Lightning Blot]]"},
+	})
+	if !ok || !edited.Edited || edited.Text != "[[Lightning Bolt]]" || edited.Timestamp != "1355517523.000005" {
+		t.Errorf("unexpected edit %+v", edited)
+	}
+
+	if _, ok := message(&slackevents.MessageEvent{
+		SubType:         "message_changed",
+		Message:         &slackevents.MessageEvent{Text: "https://example.com"},
+		PreviousMessage: &slackevents.MessageEvent{Text: "https://example.com"},
+	}); ok {
+		t.Error("an unfurl shouldn't count as an edit")
+	}
+
+	deleted, ok := message(&slackevents.MessageEvent{
+		SubType:         "message_deleted",
+		Channel:         "C2147483705",
+		PreviousMessage: &slackevents.MessageEvent{TimeStamp: "1355517523.000005"},
+	})
+	if !ok || !deleted.Deleted || deleted.Timestamp != "1355517523.000005" {
+		t.Errorf("unexpected delete %+v", deleted)
+	}
+}
+
+func TestMessageSkipsNoise(t *testing.T) {
+	if _, ok := message(&slackevents.MessageEvent{SubType: "channel_join", User: "U2147483697", Text: "joined"}); ok {
+		t.Error("a channel join shouldn't be handled")
+	}
+
+	msg, ok := message(&slackevents.MessageEvent{SubType: "bot_message", BotID: "B0OTHER", Text: "[[Lightning Bolt]]"})
+	if !ok || !msg.Bot || msg.User != "B0OTHER" {
+		t.Errorf("unexpected bot message %+v", msg)
+	}
+}
+
+func TestDispatchDropsOwnMessages(t *testing.T) {
+	slack := newFakeSlack(t)
+	defer slack.Close()
+
+	tr := NewHTTP("", testSecret, "xoxb-test", testLogger, OptionAPIURL(slack.URL+"/"))
+	if err := tr.Identify(); err != nil {
+		t.Fatal(err)
+	}
+	defer tr.Close()
+
+	own := strings.Replace(messageCallback, "U2147483697", "U0BOT", 1)
+	tr.ServeHTTP(httptest.NewRecorder(), signedRequest(own, testSecret))
+	tr.ServeHTTP(httptest.NewRecorder(), signedRequest(messageCallback, testSecret))
+	if msg := receive(t, tr.Messages()); msg.User != "U2147483697" {
+		t.Errorf("expected own message to be dropped, got %+v", msg)
+	}
+	select {
+	case msg := <-tr.Messages():
+		t.Errorf("expected own message to be dropped, got %+v", msg)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func signedForm(form url.Values) *http.Request {
+	r := signedRequest(form.Encode(), testSecret)
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	return r
+}
+
+func responded(t *testing.T, f *fakeSlack) map[string]interface{} {
+	t.Helper()
+	select {
+	case body := <-f.responded:
+		return body
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for response url post")
+	}
+	return nil
+}
+
+func TestSlashCommandShare(t *testing.T) {
+	slack := newFakeSlack(t)
+	defer slack.Close()
+	tr := NewHTTP("", testSecret, "xoxb-test", testLogger, OptionAPIURL(slack.URL+"/"))
+	defer tr.Close()
+
+	w := httptest.NewRecorder()
+	tr.ServeHTTP(w, signedForm(url.Values{
+		"command":      {"/card"},
+		"text":         {"Lightning Bolt"},
+		"user_id":      {"U2147483697"},
+		"channel_id":   {"C2147483705"},
+		"trigger_id":   {"13345224609.738474920.8088930838d88f008e0"},
+		"response_url": {slack.URL + "/respond"},
+	}))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", w.Code)
+	}
+	msg := receive(t, tr.Messages())
+	if msg.Command != "card" || msg.Text != "Lightning Bolt" || msg.Channel != "C2147483705" {
+		t.Fatalf("unexpected command %+v", msg)
+	}
+	if err := tr.Send(transport.ReplyTo(msg, transport.Response{Text: "Lightning Bolt {R}"})); err != nil {
+		t.Fatal(err)
+	}
+	body := responded(t, slack)
+	if body["response_type"] != "ephemeral" || !strings.Contains(fmt.Sprint(body["blocks"]), "/card Lightning Bolt") {
+		t.Errorf("expected an ephemeral answer with a share button, got %v", body)
+	}
+
+	payload := `{
+		"type": "block_actions",
+		"trigger_id": "13345224609.738474920.8088930838d88f008e1",
+		"response_url": "` + slack.URL + `/respond",
+		"user": {"id": "U2147483697"},
+		"channel": {"id": "C2147483705"},
+		"actions": [{"action_id": "share", "block_id": "b", "type": "button", "value": "/card Lightning Bolt"}]
+	}`
+	tr.ServeHTTP(httptest.NewRecorder(), signedForm(url.Values{"payload": {payload}}))
+	if body := responded(t, slack); body["delete_original"] != true {
+		t.Errorf("expected the ephemeral answer to be deleted, got %v", body)
+	}
+	msg = receive(t, tr.Messages())
+	if msg.Command != "card" || msg.Text != "Lightning Bolt" {
+		t.Fatalf("unexpected shared command %+v", msg)
+	}
+	if err := tr.Send(transport.ReplyTo(msg, transport.Response{Text: "Lightning Bolt {R}"})); err != nil {
+		t.Fatal(err)
+	}
+	if body := responded(t, slack); body["response_type"] != "in_channel" {
+		t.Errorf("expected the shared answer in the channel, got %v", body)
+	}
+}
+
+func TestPickReplacesMessage(t *testing.T) {
+	slack := newFakeSlack(t)
+	defer slack.Close()
+	tr := NewHTTP("", testSecret, "xoxb-test", testLogger, OptionAPIURL(slack.URL+"/"))
+	defer tr.Close()
+
+	fixture, err := ioutil.ReadFile("testdata/pick.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	payload := strings.Replace(string(fixture), "RESPONSE_URL", slack.URL+"/respond", 1)
+	tr.ServeHTTP(httptest.NewRecorder(), signedForm(url.Values{"payload": {payload}}))
+
+	msg := receive(t, tr.Messages())
+	if msg.Text != "[[Bolt of Keranos|THS]]" || msg.Command != "" || msg.User != "U2147483697" {
+		t.Fatalf("unexpected pick %+v", msg)
+	}
+	card := transport.Card{Query: "Bolt of Keranos|THS", Name: "Bolt of Keranos", Cost: ":1::r::r:"}
+	if err := tr.Send(transport.ReplyTo(msg, transport.Response{Text: "Bolt of Keranos", Cards: []transport.Card{card}})); err != nil {
+		t.Fatal(err)
+	}
+	body := responded(t, slack)
+	if body["replace_original"] != true || body["response_type"] != "in_channel" ||
+		!strings.Contains(fmt.Sprint(body["blocks"]), "*Bolt of Keranos*") {
+		t.Errorf("expected the message to be replaced with the card, got %v", body)
+	}
+}
+
+func TestSocketModePickReplacesMessage(t *testing.T) {
+	slack := newFakeSlack(t)
+	defer slack.Close()
+	fixture, err := ioutil.ReadFile("testdata/pick.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	payload := strings.Replace(string(fixture), "RESPONSE_URL", slack.URL+"/respond", 1)
+	slack.envelope = `{"type": "interactive", "envelope_id": "b7a1c3d2-0e5f-4a6b-9c8d-7e6f5a4b3c2d", "payload": ` + payload + `}`
+
+	tr := NewSocketMode("xapp-test", "xoxb-test", testLogger, OptionAPIURL(slack.URL+"/"))
+	if err := tr.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	defer tr.Close()
+
+	msg := receive(t, tr.Messages())
+	if msg.Text != "[[Bolt of Keranos|THS]]" || msg.User != "U2147483697" {
+		t.Fatalf("unexpected pick %+v", msg)
+	}
+	card := transport.Card{Query: "Bolt of Keranos|THS", Name: "Bolt of Keranos", Cost: ":1::r::r:"}
+	if err := tr.Send(transport.ReplyTo(msg, transport.Response{Text: "Bolt of Keranos", Cards: []transport.Card{card}})); err != nil {
+		t.Fatal(err)
+	}
+	if body := responded(t, slack); body["replace_original"] != true {
+		t.Errorf("expected the message to be replaced with the card, got %v", body)
+	}
+}
@@ -0,0 +1,174 @@
+package eventsapi
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/nlopes/slack"
+	"github.com/nlopes/slack/slackevents"
+)
+
+// HTTPTransport satisfies the transport.Transport interface by receiving
+// Events API callbacks over HTTP. It also takes slash commands and clicks
+// on the buttons under their answers, Slack can send all three to the
+// same URL. Every request is checked against the app's signing secret
+// before it's looked at
+type HTTPTransport struct {
+	*base
+	addr   string
+	secret string
+	server *http.Server
+
+	// queue holds whatever's been acknowledged and is waiting to be
+	// delivered, in the order it came in, so messages in a channel reach
+	// the bot in the order they were sent
+	queue chan func()
+}
+
+// NewHTTP returns an HTTPTransport that will listen for callbacks on
+// addr, verify them with signingSecret and post replies with botToken
+func NewHTTP(addr, signingSecret, botToken string, logger *slog.Logger, opts ...Option) *HTTPTransport {
+	t := &HTTPTransport{
+		base:   newBase(botToken, logger, opts),
+		addr:   addr,
+		secret: signingSecret,
+		queue:  make(chan func(), 64),
+	}
+	go t.drain()
+	return t
+}
+
+// errNoSecret is returned when there's no signing secret to check
+// requests with, without one anybody could forge them
+var errNoSecret = errors.New("the events transport needs slack's signing_secret")
+
+// Connect looks up who the bot is and starts listening for callbacks
+func (t *HTTPTransport) Connect() error {
+	if t.secret == "" {
+		return errNoSecret
+	}
+	if err := t.Identify(); err != nil {
+		return err
+	}
+	l, err := net.Listen("tcp", t.addr)
+	if err != nil {
+		return err
+	}
+	t.server = &http.Server{Handler: t}
+	t.connected.Store(true)
+	go func() {
+		if err := t.server.Serve(l); err != http.ErrServerClosed {
+			t.logger.Error("server error", "err", err)
+		}
+		t.connected.Store(false)
+	}()
+	return nil
+}
+
+// Close shuts down the callback server
+func (t *HTTPTransport) Close() error {
+	defer t.shutdown()
+	if t.server == nil {
+		return nil
+	}
+	return t.server.Close()
+}
+
+// ServeHTTP verifies and handles a single Events API callback, slash
+// command or button click
+func (t *HTTPTransport) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := verify(r.Header, body, t.secret); err != nil {
+		t.logger.Warn("rejected request", "err", err)
+		http.Error(w, "invalid signature", http.StatusUnauthorized)
+		return
+	}
+
+	// slash commands and interactions are forms, events are JSON
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "application/x-www-form-urlencoded") {
+		form, err := url.ParseQuery(string(body))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		if payload := form.Get("payload"); payload != "" {
+			t.enqueue(func() { t.interaction(payload) })
+		} else {
+			t.enqueue(func() { t.command(form) })
+		}
+		return
+	}
+
+	event, err := slackevents.ParseEvent(json.RawMessage(body), slackevents.OptionNoVerifyToken())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if event.Type == slackevents.URLVerification {
+		challenge := event.Data.(*slackevents.EventsAPIURLVerificationEvent).Challenge
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write([]byte(challenge))
+		return
+	}
+
+	// Slack retries anything not acknowledged within three seconds, so
+	// acknowledge first and let the bot take its time with the message
+	w.WriteHeader(http.StatusOK)
+	if r.Header.Get("X-Slack-Retry-Num") != "" {
+		return
+	}
+	t.enqueue(func() { t.dispatch(event) })
+}
+
+// enqueue queues f to run after everything already acknowledged, blocking
+// if the queue is full until there's room or the transport is closed
+func (t *HTTPTransport) enqueue(f func()) {
+	select {
+	case t.queue <- f:
+	case <-t.done:
+	}
+}
+
+// drain runs whatever's queued one at a time until the transport is
+// closed
+func (t *HTTPTransport) drain() {
+	for {
+		select {
+		case f := <-t.queue:
+			f()
+		case <-t.done:
+			return
+		}
+	}
+}
+
+// verify checks the request signature Slack computes from the signing
+// secret, timestamp and body
+func verify(header http.Header, body []byte, secret string) error {
+	if secret == "" {
+		return errNoSecret
+	}
+	sv, err := slack.NewSecretsVerifier(header, secret)
+	if err != nil {
+		return err
+	}
+	if _, err := sv.Write(body); err != nil {
+		return err
+	}
+	return sv.Ensure()
+}
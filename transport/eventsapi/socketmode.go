@@ -0,0 +1,176 @@
+package eventsapi
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/nlopes/slack/slackevents"
+)
+
+// SocketModeTransport satisfies the transport.Transport interface by
+// receiving Events API payloads over a Socket Mode websocket, so the bot
+// doesn't need a public HTTP endpoint. Clicks on the buttons under its
+// answers come over the websocket too
+type SocketModeTransport struct {
+	*base
+	appToken string
+	conn     *websocket.Conn
+}
+
+// envelope is the wrapper Socket Mode puts around everything it sends
+type envelope struct {
+	Type       string          `json:"type"`
+	EnvelopeID string          `json:"envelope_id"`
+	Payload    json.RawMessage `json:"payload"`
+	Reason     string          `json:"reason"`
+}
+
+// NewSocketMode returns a SocketModeTransport that opens connections with
+// the app-level appToken and posts replies with botToken
+func NewSocketMode(appToken, botToken string, logger *slog.Logger, opts ...Option) *SocketModeTransport {
+	return &SocketModeTransport{
+		base:     newBase(botToken, logger, opts),
+		appToken: appToken,
+	}
+}
+
+// Connect looks up who the bot is, then opens the first websocket
+// connection and starts reading events from it, reconnecting whenever
+// Slack asks us to or the connection drops
+func (t *SocketModeTransport) Connect() error {
+	if err := t.Identify(); err != nil {
+		return err
+	}
+	conn, err := t.dial()
+	if err != nil {
+		return err
+	}
+	go t.run(conn)
+	return nil
+}
+
+// Close drops the websocket connection
+func (t *SocketModeTransport) Close() error {
+	t.mu.RLock()
+	conn := t.conn
+	t.mu.RUnlock()
+	t.shutdown()
+	if conn != nil {
+		return conn.Close()
+	}
+	return nil
+}
+
+func (t *SocketModeTransport) run(conn *websocket.Conn) {
+	backoff := time.Second
+	for {
+		t.read(conn)
+		t.connected.Store(false)
+		conn.Close()
+
+		for {
+			select {
+			case <-t.done:
+				return
+			default:
+			}
+			var err error
+			if conn, err = t.dial(); err == nil {
+				backoff = time.Second
+				break
+			}
+			t.logger.Warn("reconnect failed", "err", err)
+			select {
+			case <-time.After(backoff):
+			case <-t.done:
+				return
+			}
+			if backoff < time.Minute {
+				backoff *= 2
+			}
+		}
+	}
+}
+
+// read handles envelopes until the connection fails or Slack tells us it's
+// about to drop it
+func (t *SocketModeTransport) read(conn *websocket.Conn) {
+	for {
+		var env envelope
+		if err := conn.ReadJSON(&env); err != nil {
+			select {
+			case <-t.done:
+			default:
+				t.logger.Warn("read error", "err", err)
+			}
+			return
+		}
+		if env.EnvelopeID != "" {
+			ack := struct {
+				EnvelopeID string `json:"envelope_id"`
+			}{env.EnvelopeID}
+			if err := conn.WriteJSON(ack); err != nil {
+				t.logger.Error("ack error", "err", err)
+			}
+		}
+
+		switch env.Type {
+		case "events_api":
+			event, err := slackevents.ParseEvent(env.Payload, slackevents.OptionNoVerifyToken())
+			if err != nil {
+				t.logger.Error("error parsing event", "err", err)
+				continue
+			}
+			t.dispatch(event)
+		case "interactive":
+			t.interaction(string(env.Payload))
+		case "disconnect":
+			t.logger.Info("disconnect requested", "reason", env.Reason)
+			return
+		}
+	}
+}
+
+// dial asks apps.connections.open for a websocket url and connects to it
+func (t *SocketModeTransport) dial() (*websocket.Conn, error) {
+	req, err := http.NewRequest(http.MethodPost,
+		strings.TrimSuffix(t.apiURL, "/")+"/apps.connections.open", nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+t.appToken)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var open struct {
+		OK    bool   `json:"ok"`
+		Error string `json:"error"`
+		URL   string `json:"url"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&open); err != nil {
+		return nil, err
+	}
+	if !open.OK {
+		return nil, errors.New("apps.connections.open: " + open.Error)
+	}
+
+	conn, _, err := websocket.DefaultDialer.Dial(open.URL, nil)
+	if err != nil {
+		return nil, err
+	}
+	t.mu.Lock()
+	t.conn = conn
+	t.mu.Unlock()
+	t.connected.Store(true)
+	return conn, nil
+}
@@ -0,0 +1,78 @@
+package irc
+
+import (
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+// maxLineBytes leaves room in the 512 byte IRC line limit for the
+// PRIVMSG command, the target, and the prefix the server adds when it
+// relays the line to everyone else
+const maxLineBytes = 400
+
+var emojiCode = regexp.MustCompile(`:([0-9a-z]+):`)
+
+// Lines turns a handler response into plain IRC lines: code fences are
+// dropped, mana symbol emoji codes are turned back into {W} style
+// symbols, and anything too long is wrapped at word boundaries
+func Lines(text string) []string {
+	text = strings.Replace(text, "```", "\n", -1)
+	text = emojiCode.ReplaceAllStringFunc(text, func(code string) string {
+		if sym, ok := manaSymbol(code[1 : len(code)-1]); ok {
+			return sym
+		}
+		return code
+	})
+
+	var lines []string
+	for _, line := range strings.Split(text, "\n") {
+		line = strings.Join(strings.Fields(line), " ")
+		if line == "" {
+			continue
+		}
+		lines = append(lines, wrap(line, maxLineBytes)...)
+	}
+	return lines
+}
+
+// manaSymbol undoes the symbol mapping cardbase's formatCost applies to
+// mana costs, returning false for codes that aren't mana symbols
+func manaSymbol(code string) (string, bool) {
+	const colors = "wubrg"
+	upper := strings.ToUpper(code)
+	switch {
+	case strings.Trim(code, "0123456789") == "":
+		return "{" + code + "}", true
+	case len(code) == 2 && code[0] == code[1] && strings.ContainsAny(code[:1], colors+"x"):
+		return "{" + upper[:1] + "}", true
+	case len(code) == 2 && code[0] == '2' && strings.ContainsAny(code[1:], colors):
+		return "{2/" + upper[1:] + "}", true
+	case len(code) == 2 && code[1] == 'p' && strings.ContainsAny(code[:1], colors):
+		return "{" + upper[:1] + "/P}", true
+	case len(code) == 2 && strings.ContainsAny(code[:1], colors) && strings.ContainsAny(code[1:], colors):
+		return "{" + upper[:1] + "/" + upper[1:] + "}", true
+	}
+	return "", false
+}
+
+// wrap breaks line into pieces of at most max bytes, preferring to break
+// on spaces and never splitting a multi-byte character
+func wrap(line string, max int) []string {
+	var lines []string
+	for len(line) > max {
+		cut := strings.LastIndex(line[:max+1], " ")
+		if cut <= 0 {
+			cut = max
+			for cut > 0 && !utf8.RuneStart(line[cut]) {
+				cut--
+			}
+		}
+		lines = append(lines, strings.TrimRight(line[:cut], " "))
+		line = strings.TrimLeft(line[cut:], " ")
+	}
+	if line != "" {
+		lines = append(lines, line)
+	}
+	return lines
+}
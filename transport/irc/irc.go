@@ -0,0 +1,361 @@
+package irc
+
+import (
+	"bufio"
+	"crypto/tls"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/komon/gosukebot/transport"
+)
+
+// Config holds everything needed to connect to an IRC network
+type Config struct {
+	// Server is the host:port to connect to
+	Server string
+	// TLS connects with TLS when set
+	TLS  bool
+	Nick string
+	// User and RealName default to Nick when empty
+	User     string
+	RealName string
+	// Password is sent with PASS when set
+	Password string
+	// SASLPassword authenticates Nick with SASL PLAIN when set
+	SASLPassword string
+	// NickServPassword identifies Nick with NickServ after connecting
+	// when set, it's ignored if SASLPassword is set
+	NickServPassword string
+	Channels         []string
+}
+
+// Transport satisfies the transport.Transport interface over an IRC
+// client connection, reconnecting whenever the connection drops. Replies
+// are split into lines and paced so the server doesn't kick us for
+// flooding
+type Transport struct {
+	cfg      Config
+	logger   *slog.Logger
+	messages chan transport.Message
+	lines    chan string
+	done     chan struct{}
+
+	mu   sync.Mutex
+	conn net.Conn
+	nick string
+	// registered is whether the server has welcomed us on the current
+	// connection
+	registered bool
+}
+
+// New returns a new Transport for the given config
+func New(cfg Config, logger *slog.Logger) *Transport {
+	if cfg.User == "" {
+		cfg.User = cfg.Nick
+	}
+	if cfg.RealName == "" {
+		cfg.RealName = cfg.Nick
+	}
+	return &Transport{
+		cfg:      cfg,
+		logger:   logger,
+		messages: make(chan transport.Message),
+		lines:    make(chan string, 64),
+		done:     make(chan struct{}),
+		nick:     cfg.Nick,
+	}
+}
+
+// Connect makes the first connection and registers with the server, the
+// connection is maintained in the background from then on
+func (t *Transport) Connect() error {
+	conn, err := t.dial()
+	if err != nil {
+		return err
+	}
+	go t.run(conn)
+	go t.pace()
+	return nil
+}
+
+// Messages returns the channel incoming messages are delivered on
+func (t *Transport) Messages() <-chan transport.Message {
+	return t.messages
+}
+
+// Send queues a reply as one PRIVMSG per line, ephemeral replies go to
+// the user as NOTICEs instead
+func (t *Transport) Send(r transport.Reply) error {
+	command, target := "PRIVMSG", r.Channel
+	if r.Ephemeral && r.User != "" {
+		command, target = "NOTICE", r.User
+	}
+	for _, line := range Lines(r.Text) {
+		select {
+		case t.lines <- fmt.Sprintf("%s %s :%s", command, target, line):
+		case <-t.done:
+			return fmt.Errorf("irc: transport closed")
+		}
+	}
+	return nil
+}
+
+// Check satisfies transport.Checker
+func (t *Transport) Check() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if !t.registered {
+		return errors.New("not connected")
+	}
+	return nil
+}
+
+// Close quits and drops the connection
+func (t *Transport) Close() error {
+	close(t.done)
+	t.write("QUIT :bye")
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.conn != nil {
+		return t.conn.Close()
+	}
+	return nil
+}
+
+func (t *Transport) dial() (net.Conn, error) {
+	var (
+		conn net.Conn
+		err  error
+	)
+	if t.cfg.TLS {
+		conn, err = tls.Dial("tcp", t.cfg.Server, nil)
+	} else {
+		conn, err = net.Dial("tcp", t.cfg.Server)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	t.mu.Lock()
+	t.conn = conn
+	t.nick = t.cfg.Nick
+	t.mu.Unlock()
+
+	if t.cfg.SASLPassword != "" {
+		t.write("CAP REQ :sasl")
+	}
+	if t.cfg.Password != "" {
+		t.write("PASS " + t.cfg.Password)
+	}
+	t.write("NICK " + t.cfg.Nick)
+	t.write(fmt.Sprintf("USER %s 0 * :%s", t.cfg.User, t.cfg.RealName))
+	return conn, nil
+}
+
+func (t *Transport) run(conn net.Conn) {
+	defer close(t.messages)
+	backoff := time.Second
+	for {
+		t.read(conn)
+		t.mu.Lock()
+		t.registered = false
+		t.mu.Unlock()
+		conn.Close()
+
+		for {
+			select {
+			case <-t.done:
+				return
+			case <-time.After(backoff):
+			}
+			var err error
+			if conn, err = t.dial(); err == nil {
+				backoff = time.Second
+				break
+			}
+			t.logger.Warn("reconnect failed", "err", err)
+			if backoff < 5*time.Minute {
+				backoff *= 2
+			}
+		}
+	}
+}
+
+// read handles lines from the server until the connection fails
+func (t *Transport) read(conn net.Conn) {
+	scanner := bufio.NewScanner(conn)
+	for scanner.Scan() {
+		prefix, command, params := parseLine(scanner.Text())
+		switch command {
+		case "PING":
+			t.write("PONG :" + last(params))
+		case "CAP":
+			if len(params) > 1 && params[1] == "ACK" {
+				t.write("AUTHENTICATE PLAIN")
+			} else if len(params) > 1 && params[1] == "NAK" {
+				t.logger.Error("server doesn't support sasl")
+				t.write("CAP END")
+			}
+		case "AUTHENTICATE":
+			if last(params) == "+" {
+				t.write("AUTHENTICATE " + saslPlain(t.cfg.Nick, t.cfg.SASLPassword))
+			}
+		case "903":
+			t.write("CAP END")
+		case "902", "904", "905", "906":
+			t.logger.Error("sasl authentication failed", "reason", last(params))
+			t.write("CAP END")
+		case "001":
+			t.mu.Lock()
+			t.registered = true
+			t.mu.Unlock()
+			if t.cfg.NickServPassword != "" && t.cfg.SASLPassword == "" {
+				t.write("PRIVMSG NickServ :IDENTIFY " + t.cfg.NickServPassword)
+			}
+			for _, ch := range t.cfg.Channels {
+				t.write("JOIN " + ch)
+			}
+		case "433":
+			t.mu.Lock()
+			t.nick += "_"
+			nick := t.nick
+			t.mu.Unlock()
+			t.write("NICK " + nick)
+		case "PRIVMSG":
+			if len(params) < 2 {
+				continue
+			}
+			t.deliver(prefix, params[0], params[1])
+		case "ERROR":
+			t.logger.Error("server error", "reason", last(params))
+		}
+	}
+	select {
+	case <-t.done:
+	default:
+		t.logger.Warn("connection lost", "err", scanner.Err())
+	}
+}
+
+func (t *Transport) deliver(prefix, target, text string) {
+	sender := prefix
+	if i := strings.Index(sender, "!"); i != -1 {
+		sender = sender[:i]
+	}
+	t.mu.Lock()
+	self := strings.EqualFold(sender, t.nick)
+	private := strings.EqualFold(target, t.nick)
+	t.mu.Unlock()
+	// some bouncers echo our own messages back
+	if self {
+		return
+	}
+	// nicks are first come first served, anybody can use an admin's
+	msg := transport.Message{
+		Text:       text,
+		User:       sender,
+		UserName:   sender,
+		Channel:    target,
+		Timestamp:  fmt.Sprintf("%d", time.Now().UnixNano()),
+		Unverified: true,
+	}
+	// replies to private messages go back to whoever sent them
+	if private {
+		msg.Channel = sender
+	} else {
+		msg.ChannelName = target
+	}
+	select {
+	case t.messages <- msg:
+	case <-t.done:
+	}
+}
+
+// pace writes queued lines, allowing a short burst and then slowing down
+// to one line per floodDelay, which is about what most networks tolerate
+func (t *Transport) pace() {
+	const (
+		burst      = 4
+		floodDelay = time.Second
+	)
+	tokens := burst
+	refill := time.NewTicker(floodDelay)
+	defer refill.Stop()
+	for {
+		if tokens == 0 {
+			select {
+			case <-refill.C:
+				tokens++
+			case <-t.done:
+				return
+			}
+			continue
+		}
+		select {
+		case line := <-t.lines:
+			t.write(line)
+			tokens--
+		case <-refill.C:
+			if tokens < burst {
+				tokens++
+			}
+		case <-t.done:
+			return
+		}
+	}
+}
+
+func (t *Transport) write(line string) {
+	t.mu.Lock()
+	conn := t.conn
+	t.mu.Unlock()
+	if conn == nil {
+		return
+	}
+	if _, err := fmt.Fprintf(conn, "%s\r\n", line); err != nil {
+		t.logger.Error("write error", "err", err)
+	}
+}
+
+// parseLine splits a raw IRC line into its prefix, command and
+// parameters, the trailing parameter has its leading colon removed
+func parseLine(line string) (prefix, command string, params []string) {
+	if strings.HasPrefix(line, ":") {
+		i := strings.Index(line, " ")
+		if i == -1 {
+			return line[1:], "", nil
+		}
+		prefix, line = line[1:i], line[i+1:]
+	}
+	trailing := ""
+	hasTrailing := false
+	if i := strings.Index(line, " :"); i != -1 {
+		trailing, line, hasTrailing = line[i+2:], line[:i], true
+	}
+	fields := strings.Fields(line)
+	if len(fields) == 0 {
+		return prefix, "", nil
+	}
+	command, params = strings.ToUpper(fields[0]), fields[1:]
+	if hasTrailing {
+		params = append(params, trailing)
+	}
+	return prefix, command, params
+}
+
+func last(params []string) string {
+	if len(params) == 0 {
+		return ""
+	}
+	return params[len(params)-1]
+}
+
+func saslPlain(nick, password string) string {
+	return base64.StdEncoding.EncodeToString([]byte(nick + "\x00" + nick + "\x00" + password))
+}
@@ -0,0 +1,118 @@
+package irc
+
+import (
+	"bufio"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/komon/gosukebot/logging"
+	"github.com/komon/gosukebot/transport"
+)
+
+func TestLines(t *testing.T) {
+	lines := Lines("http://gatherer.wizards.com/Handlers/Image.ashx?multiverseid=1&type=card " +
+		":2::rr: ```Lightning Bolt deals 3 damage to any target.\nFlashback :wp::ub:``` M11")
+	want := []string{
+		"http://gatherer.wizards.com/Handlers/Image.ashx?multiverseid=1&type=card {2}{R}",
+		"Lightning Bolt deals 3 damage to any target.",
+		"Flashback {W/P}{U/B}",
+		"M11",
+	}
+	if !reflect.DeepEqual(lines, want) {
+		t.Errorf("expected %q, got %q", want, lines)
+	}
+
+	if lines := Lines("Count: 3\n\n:menacing: :xx:"); !reflect.DeepEqual(lines, []string{"Count: 3", ":menacing: {X}"}) {
+		t.Errorf("unexpected lines %q", lines)
+	}
+}
+
+func TestWrap(t *testing.T) {
+	long := strings.Repeat("ゴ ", 300)
+	for _, line := range Lines(long) {
+		if len(line) > maxLineBytes {
+			t.Errorf("line of %d bytes is over the limit", len(line))
+		}
+	}
+	if got := wrap("aaaa bbbb cccc", 9); !reflect.DeepEqual(got, []string{"aaaa bbbb", "cccc"}) {
+		t.Errorf("unexpected wrap %q", got)
+	}
+	if got := wrap("ゴゴゴ", 4); !reflect.DeepEqual(got, []string{"ゴ", "ゴ", "ゴ"}) {
+		t.Errorf("wrap split a character: %q", got)
+	}
+}
+
+func TestParseLine(t *testing.T) {
+	prefix, command, params := parseLine(":dio!dio@example.com PRIVMSG #jojo :hello, jojo!")
+	if prefix != "dio!dio@example.com" || command != "PRIVMSG" ||
+		!reflect.DeepEqual(params, []string{"#jojo", "hello, jojo!"}) {
+		t.Errorf("unexpected parse %q %q %q", prefix, command, params)
+	}
+	if _, command, params := parseLine("PING :irc.example.com"); command != "PING" || last(params) != "irc.example.com" {
+		t.Errorf("unexpected parse %q %q", command, params)
+	}
+}
+
+func TestSession(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	tr := New(Config{
+		Server:           l.Addr().String(),
+		Nick:             "jojo",
+		NickServPassword: "hunter2",
+		Channels:         []string{"#jojo"},
+	}, logging.Discard())
+	if err := tr.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	defer tr.Close()
+
+	conn, err := l.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	server := bufio.NewReader(conn)
+	expect := func(want string) {
+		t.Helper()
+		line, err := server.ReadString('\n')
+		if err != nil {
+			t.Fatal(err)
+		}
+		if line = strings.TrimRight(line, "\r\n"); line != want {
+			t.Fatalf("expected %q, got %q", want, line)
+		}
+	}
+
+	expect("NICK jojo")
+	expect("USER jojo 0 * :jojo")
+	conn.Write([]byte(":irc.example.com 001 jojo :Welcome\r\n"))
+	expect("PRIVMSG NickServ :IDENTIFY hunter2")
+	expect("JOIN #jojo")
+	conn.Write([]byte("PING :irc.example.com\r\n"))
+	expect("PONG :irc.example.com")
+
+	conn.Write([]byte(":dio!dio@example.com PRIVMSG #jojo :[[Lightning Bolt]]\r\n"))
+	msg := <-tr.Messages()
+	if msg.Text != "[[Lightning Bolt]]" || msg.User != "dio" || msg.Channel != "#jojo" || !msg.Unverified {
+		t.Errorf("unexpected message %+v", msg)
+	}
+	tr.Send(transport.ReplyTo(msg, transport.Response{Text: ":rr: ```Lightning Bolt deals 3 damage to any target.```"}))
+	expect("PRIVMSG #jojo :{R}")
+	expect("PRIVMSG #jojo :Lightning Bolt deals 3 damage to any target.")
+	tr.Send(transport.ReplyTo(msg, transport.Response{Text: "Slow down", Ephemeral: true}))
+	expect("NOTICE dio :Slow down")
+
+	conn.Write([]byte(":dio!dio@example.com PRIVMSG jojo :hello, jojo\r\n"))
+	if msg := <-tr.Messages(); msg.Channel != "dio" {
+		t.Errorf("private message should be answered privately, got channel %q", msg.Channel)
+	}
+}
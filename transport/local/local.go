@@ -0,0 +1,102 @@
+package local
+
+import (
+	"sync"
+
+	"github.com/komon/gosukebot/transport"
+)
+
+// Transport satisfies the transport.Transport and transport.Editor
+// interfaces entirely in memory, messages are fed in with Receive and
+// replies are collected for inspection with Sent. It's meant for
+// exercising handlers in tests without a live chat connection
+type Transport struct {
+	messages chan transport.Message
+	replies  chan transport.Reply
+
+	mu   sync.Mutex
+	sent []transport.Reply
+}
+
+// New returns a new in-memory Transport
+func New() *Transport {
+	return &Transport{
+		messages: make(chan transport.Message, 16),
+		replies:  make(chan transport.Reply, 16),
+	}
+}
+
+// Connect is a no-op, the transport is ready as soon as it's created
+func (t *Transport) Connect() error {
+	return nil
+}
+
+// Messages returns the channel messages passed to Receive arrive on
+func (t *Transport) Messages() <-chan transport.Message {
+	return t.messages
+}
+
+// Send records the reply and makes it available on Replies
+func (t *Transport) Send(r transport.Reply) error {
+	t.mu.Lock()
+	t.sent = append(t.sent, r)
+	t.mu.Unlock()
+	select {
+	case t.replies <- r:
+	default:
+	}
+	return nil
+}
+
+// Edit replaces the revisable reply to r.Source in Sent, or sends r if
+// there isn't one
+func (t *Transport) Edit(r transport.Reply) error {
+	t.mu.Lock()
+	for i, s := range t.sent {
+		if s.Channel == r.Channel && s.Source == r.Source && s.Revisable {
+			t.sent[i] = r
+			t.mu.Unlock()
+			return nil
+		}
+	}
+	t.mu.Unlock()
+	return t.Send(r)
+}
+
+// Delete removes the revisable replies to source from Sent
+func (t *Transport) Delete(channel, source string) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	kept := t.sent[:0]
+	for _, s := range t.sent {
+		if s.Channel != channel || s.Source != source || !s.Revisable {
+			kept = append(kept, s)
+		}
+	}
+	t.sent = kept
+	return nil
+}
+
+// Close closes the Messages channel
+func (t *Transport) Close() error {
+	close(t.messages)
+	return nil
+}
+
+// Receive delivers msg as if it had come from the chat system
+func (t *Transport) Receive(msg transport.Message) {
+	t.messages <- msg
+}
+
+// Replies returns a channel that replies are copied to as they're sent,
+// replies are dropped from it if nobody is reading
+func (t *Transport) Replies() <-chan transport.Reply {
+	return t.replies
+}
+
+// Sent returns every reply sent so far, as edited
+func (t *Transport) Sent() []transport.Reply {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return append([]transport.Reply(nil), t.sent...)
+}
@@ -0,0 +1,263 @@
+package outbox
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/komon/gosukebot/transport"
+)
+
+// Options tune how an Outbox sends replies
+type Options struct {
+	// Interval is the least time between two messages to one channel
+	Interval time.Duration
+	// MaxLen is the most text one message can have, in bytes. Longer
+	// replies are split, 0 means they never are
+	MaxLen int
+	// Retries is how many times a rate limited message is tried again
+	Retries int
+}
+
+// Slack follows Slack's guidance of one message a second per channel, and
+// its 4000 character message limit
+var Slack = Options{Interval: time.Second, MaxLen: 4000, Retries: 3}
+
+// Outbox wraps a transport, queueing replies for each channel and sending
+// them no faster than the chat system allows. Long replies are split up
+// and rate limited sends are retried, so nothing gets dropped or cut off
+// on the other end. Send only fails once the outbox is closed, errors
+// from the wrapped transport are passed on to whatever's registered with
+// Sent, or logged if nothing is. Edits and deletes are queued
+// along with replies, so they happen after whatever they're changing has
+// been sent, as long as the wrapped transport is a transport.Editor
+type Outbox struct {
+	transport.Transport
+	opts   Options
+	logger *slog.Logger
+
+	mu     sync.RWMutex
+	closed bool
+	sent   func(transport.Reply, error)
+
+	qmu    sync.Mutex
+	queues map[string]chan op
+	wg     sync.WaitGroup
+}
+
+// op is something queued for a channel: a reply to send, or an edit or
+// delete of an earlier one
+type op struct {
+	kind  opKind
+	reply transport.Reply
+}
+
+type opKind int
+
+const (
+	opSend opKind = iota
+	opEdit
+	opDelete
+)
+
+// New returns an Outbox sending over t
+func New(t transport.Transport, opts Options, logger *slog.Logger) *Outbox {
+	return &Outbox{
+		Transport: t,
+		opts:      opts,
+		logger:    logger,
+		queues:    map[string]chan op{},
+	}
+}
+
+// Send queues r to be sent after anything else queued for its channel,
+// blocking if that channel is backed up
+func (o *Outbox) Send(r transport.Reply) error {
+	return o.push(op{opSend, r})
+}
+
+// Edit queues an edit of the reply to r.Source
+func (o *Outbox) Edit(r transport.Reply) error {
+	if _, ok := o.Transport.(transport.Editor); !ok {
+		return fmt.Errorf("outbox: transport can't edit replies")
+	}
+	return o.push(op{opEdit, r})
+}
+
+// Delete queues deleting the reply to source
+func (o *Outbox) Delete(channel, source string) error {
+	if _, ok := o.Transport.(transport.Editor); !ok {
+		return fmt.Errorf("outbox: transport can't delete replies")
+	}
+	return o.push(op{opDelete, transport.Reply{Channel: channel, Source: source}})
+}
+
+func (o *Outbox) push(op op) error {
+	o.mu.RLock()
+	defer o.mu.RUnlock()
+	if o.closed {
+		return fmt.Errorf("outbox: closed")
+	}
+	o.queue(op.reply.Channel) <- op
+	return nil
+}
+
+// Sent satisfies transport.Queuer, f is called once each reply or edit
+// has been sent, with the first error if any part of it couldn't be
+func (o *Outbox) Sent(f func(r transport.Reply, err error)) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.sent = f
+}
+
+// Check asks the wrapped transport whether it's connected, if it knows
+func (o *Outbox) Check() error {
+	if c, ok := o.Transport.(transport.Checker); ok {
+		return c.Check()
+	}
+	return nil
+}
+
+// Name has the wrapped transport fill in msg's names, if it looks them up
+func (o *Outbox) Name(msg *transport.Message) {
+	if n, ok := o.Transport.(transport.Namer); ok {
+		n.Name(msg)
+	}
+}
+
+// Close sends everything still queued, then closes the wrapped transport
+func (o *Outbox) Close() error {
+	o.mu.Lock()
+	if !o.closed {
+		o.closed = true
+		o.qmu.Lock()
+		for _, q := range o.queues {
+			close(q)
+		}
+		o.qmu.Unlock()
+	}
+	o.mu.Unlock()
+	o.wg.Wait()
+	return o.Transport.Close()
+}
+
+// queue returns the queue for channel, starting one if there isn't one
+func (o *Outbox) queue(channel string) chan<- op {
+	o.qmu.Lock()
+	defer o.qmu.Unlock()
+	q, ok := o.queues[channel]
+	if !ok {
+		q = make(chan op, 64)
+		o.queues[channel] = q
+		o.wg.Add(1)
+		go o.drain(q)
+	}
+	return q
+}
+
+func (o *Outbox) drain(q <-chan op) {
+	defer o.wg.Done()
+	var last time.Time
+	for op := range q {
+		var first error
+		for _, call := range o.calls(op) {
+			if wait := o.opts.Interval - time.Since(last); wait > 0 {
+				time.Sleep(wait)
+			}
+			if err := o.retry(op.reply.Channel, call); err != nil && first == nil {
+				first = err
+			}
+			last = time.Now()
+		}
+		o.report(op, first)
+	}
+}
+
+// report passes on how op went to whatever's registered with Sent,
+// logging errors if nothing is. Deletes aren't reported, nothing needs to
+// know about them
+func (o *Outbox) report(op op, err error) {
+	o.mu.RLock()
+	sent := o.sent
+	o.mu.RUnlock()
+	switch {
+	case op.kind == opDelete:
+		if err != nil {
+			o.logger.Error("delete error", "channel", op.reply.Channel, "err", err)
+		}
+	case sent != nil:
+		sent(op.reply, err)
+	case err != nil:
+		o.logger.Error("send error", "channel", op.reply.Channel, "err", err)
+	}
+}
+
+// calls turns op into the calls to make to the wrapped transport, one
+// per message sent, changed or deleted. An edit that has to be split is
+// sent again from scratch rather than trying to line the parts up
+func (o *Outbox) calls(op op) []func() error {
+	var calls []func() error
+	sends := func(parts []transport.Reply) {
+		for _, part := range parts {
+			part := part
+			calls = append(calls, func() error { return o.Transport.Send(part) })
+		}
+	}
+	ed, _ := o.Transport.(transport.Editor)
+	r := op.reply
+	switch op.kind {
+	case opSend:
+		sends(o.split(r))
+	case opEdit:
+		parts := o.split(r)
+		if len(parts) == 1 {
+			calls = append(calls, func() error { return ed.Edit(parts[0]) })
+			break
+		}
+		calls = append(calls, func() error { return ed.Delete(r.Channel, r.Source) })
+		sends(parts)
+	case opDelete:
+		calls = append(calls, func() error { return ed.Delete(r.Channel, r.Source) })
+	}
+	return calls
+}
+
+// split breaks r up into replies short enough to send. Card replies are
+// rendered from their cards, so they stay in one piece and only their
+// text, which is just for notifications, is cut short
+func (o *Outbox) split(r transport.Reply) []transport.Reply {
+	texts := Split(r.Text, o.opts.MaxLen)
+	if len(r.Cards) != 0 {
+		r.Text = texts[0]
+		return []transport.Reply{r}
+	}
+	parts := make([]transport.Reply, len(texts))
+	for i, text := range texts {
+		parts[i] = r
+		parts[i].Text = text
+	}
+	return parts
+}
+
+// retry makes call, trying again if the chat system says it's being
+// rate limited, and returns the error it gave up with
+func (o *Outbox) retry(channel string, call func() error) error {
+	for try := 0; ; try++ {
+		err := call()
+		if err == nil {
+			return nil
+		}
+		var rl *transport.RateLimitError
+		if !errors.As(err, &rl) || try >= o.opts.Retries {
+			return err
+		}
+		wait := rl.RetryAfter
+		if wait <= 0 {
+			wait = o.opts.Interval
+		}
+		o.logger.Warn("rate limited, retrying", "channel", channel, "wait", wait)
+		time.Sleep(wait)
+	}
+}
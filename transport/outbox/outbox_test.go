@@ -0,0 +1,118 @@
+package outbox
+
+import (
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/komon/gosukebot/logging"
+	"github.com/komon/gosukebot/transport"
+	"github.com/komon/gosukebot/transport/local"
+)
+
+func TestSplit(t *testing.T) {
+	cases := []struct {
+		text string
+		max  int
+		want []string
+	}{
+		{"short", 100, []string{"short"}},
+		{"ora\nora\nora\nmuda", 14, []string{"ora\nora\nora", "muda"}},
+		{"Count: 12\n```ora\nora\nmuda\nmuda```\nSum: 3", 18, []string{"Count: 12", "```ora\nora\n```", "```muda\nmuda```", "Sum: 3"}},
+		{"ora ora ora ora", 12, []string{"ora ora", "ora ora"}},
+	}
+	for _, c := range cases {
+		got := Split(c.text, c.max)
+		if strings.Join(got, "|") != strings.Join(c.want, "|") {
+			t.Errorf("Split(%q, %d): expected %q, got %q", c.text, c.max, c.want, got)
+		}
+	}
+}
+
+func TestSplitKeepsCodeBlocksWhole(t *testing.T) {
+	var lines []string
+	for i := 0; i < 200; i++ {
+		lines = append(lines, "Lightning Bolt {R} ```Lightning Bolt deals 3 damage to any target.\nInstant``` M10")
+	}
+	for i, part := range Split(strings.Join(lines, "\n"), 4000) {
+		if len(part) > 4000 {
+			t.Errorf("part %d is %d bytes", i, len(part))
+		}
+		if strings.Count(part, fence)%2 != 0 {
+			t.Errorf("part %d leaves a code block open: %q", i, part)
+		}
+	}
+}
+
+// flaky is rate limited on its first send
+type flaky struct {
+	*local.Transport
+	mu    sync.Mutex
+	tries int
+}
+
+func (f *flaky) Send(r transport.Reply) error {
+	f.mu.Lock()
+	f.tries++
+	first := f.tries == 1
+	f.mu.Unlock()
+	if first {
+		return &transport.RateLimitError{RetryAfter: 10 * time.Millisecond}
+	}
+	return f.Transport.Send(r)
+}
+
+func TestOutbox(t *testing.T) {
+	tr := &flaky{Transport: local.New()}
+	o := New(tr, Options{Interval: 20 * time.Millisecond, MaxLen: 14, Retries: 1}, logging.Discard())
+
+	start := time.Now()
+	o.Send(transport.Reply{Response: transport.Response{Text: "ora\nora\nora\nmuda"}, Channel: "C1"})
+	o.Send(transport.Reply{Response: transport.Response{Text: "muda"}, Channel: "C1"})
+	o.Close()
+
+	var got []string
+	for _, r := range tr.Sent() {
+		got = append(got, r.Text)
+	}
+	if strings.Join(got, "|") != "ora\nora\nora|muda|muda" {
+		t.Errorf("unexpected replies %q", got)
+	}
+	if took := time.Since(start); took < 40*time.Millisecond {
+		t.Errorf("replies weren't paced, took %s", took)
+	}
+	if err := o.Send(transport.Reply{Channel: "C1"}); err == nil {
+		t.Error("expected Send to fail after Close")
+	}
+}
+
+// broken can't send anything
+type broken struct {
+	*local.Transport
+}
+
+func (broken) Send(r transport.Reply) error {
+	return errors.New("channel_not_found")
+}
+
+func TestOutboxReportsSent(t *testing.T) {
+	o := New(broken{local.New()}, Options{Retries: 1}, logging.Discard())
+	var (
+		mu   sync.Mutex
+		errs []error
+	)
+	o.Sent(func(r transport.Reply, err error) {
+		mu.Lock()
+		errs = append(errs, err)
+		mu.Unlock()
+	})
+	o.Send(transport.Reply{Response: transport.Response{Text: "ora"}, Channel: "C1", Source: "1"})
+	o.Delete("C1", "1")
+	o.Close()
+
+	if len(errs) != 1 || errs[0] == nil || errs[0].Error() != "channel_not_found" {
+		t.Errorf("expected the failed send to be reported, got %v", errs)
+	}
+}
@@ -0,0 +1,88 @@
+package outbox
+
+import (
+	"strings"
+	"unicode/utf8"
+)
+
+const fence = "```"
+
+// Split breaks text into parts of at most max bytes, on line boundaries
+// where it can. A code block that has to be broken up is closed at the
+// end of one part and opened again at the start of the next, so every
+// part renders on its own. A max of 0 or less never splits
+func Split(text string, max int) []string {
+	if max <= 0 || len(text) <= max {
+		return []string{text}
+	}
+	// there has to be room for a fence at each end and something between
+	if max < 3*len(fence) {
+		max = 3 * len(fence)
+	}
+
+	var (
+		parts []string
+		b     strings.Builder
+		// open is whether b ends inside a code block, start is how much
+		// of b is the fence reopening it
+		open  bool
+		start int
+	)
+	flush := func() {
+		part := strings.TrimRight(b.String(), "\n ")
+		if open {
+			part += "\n" + fence
+		}
+		parts = append(parts, part)
+		b.Reset()
+		start = 0
+		if open {
+			b.WriteString(fence)
+			start = len(fence)
+		}
+	}
+
+	for _, line := range strings.SplitAfter(text, "\n") {
+		for line != "" {
+			// leave room to close the code block if line leaves one open
+			room := max - b.Len() - len(fence) - 1
+			if !toggle(open, line) && len(line) <= max-b.Len() || len(line) <= room {
+				b.WriteString(line)
+				open = toggle(open, line)
+				break
+			}
+			if b.Len() > start {
+				flush()
+				continue
+			}
+			// line won't fit even on its own, so it has to be cut
+			cut := cutAt(line, room)
+			b.WriteString(line[:cut])
+			open = toggle(open, line[:cut])
+			line = line[cut:]
+			flush()
+		}
+	}
+	if b.Len() > start {
+		parts = append(parts, strings.TrimRight(b.String(), "\n "))
+	}
+	return parts
+}
+
+// toggle reports whether a code block is open after s, given whether one
+// was open before it
+func toggle(open bool, s string) bool {
+	return open != (strings.Count(s, fence)%2 == 1)
+}
+
+// cutAt returns where to cut s so the first piece is at most n bytes,
+// after the last space if there is one and never inside a rune
+func cutAt(s string, n int) int {
+	if i := strings.LastIndexByte(s[:n], ' '); i > 0 {
+		return i + 1
+	}
+	for n > 1 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	return n
+}
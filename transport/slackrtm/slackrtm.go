@@ -0,0 +1,147 @@
+package slackrtm
+
+import (
+	"errors"
+	"log/slog"
+	"sync/atomic"
+
+	"github.com/komon/gosukebot/logging"
+	"github.com/komon/gosukebot/transport"
+	"github.com/komon/gosukebot/transport/slackweb"
+	"github.com/nlopes/slack"
+)
+
+// Transport satisfies the transport.Transport interface using the
+// Slack RTM websocket API. Replies go out through the web API, RTM can't
+// carry blocks or tell us what it sent so it can be edited later
+type Transport struct {
+	*slackweb.Poster
+	rtm      *slack.RTM
+	logger   *slog.Logger
+	messages chan transport.Message
+	done     chan struct{}
+	// connected follows the RTM connection's events
+	connected atomic.Bool
+}
+
+// New returns a new Transport for the given bot token, connection
+// events and errors are written to logger
+func New(token string, logger *slog.Logger) *Transport {
+	api := slack.New(token, slack.OptionLog(logging.Std(logger, slog.LevelInfo)))
+	return &Transport{
+		Poster:   slackweb.New(api),
+		rtm:      api.NewRTM(),
+		logger:   logger,
+		messages: make(chan transport.Message),
+		done:     make(chan struct{}),
+	}
+}
+
+// Connect looks up who the bot is, then starts managing the RTM
+// connection and pumping message events onto the Messages channel
+func (t *Transport) Connect() error {
+	if err := t.Identify(); err != nil {
+		return err
+	}
+	go t.rtm.ManageConnection()
+	go t.pump()
+	return nil
+}
+
+// Messages returns the channel incoming messages are delivered on
+func (t *Transport) Messages() <-chan transport.Message {
+	return t.messages
+}
+
+// Check satisfies transport.Checker
+func (t *Transport) Check() error {
+	if !t.connected.Load() {
+		return errors.New("not connected")
+	}
+	return nil
+}
+
+// Close disconnects the RTM connection
+func (t *Transport) Close() error {
+	close(t.done)
+	return t.rtm.Disconnect()
+}
+
+func (t *Transport) pump() {
+	defer close(t.messages)
+	for {
+		select {
+		case event := <-t.rtm.IncomingEvents:
+			switch ev := event.Data.(type) {
+			case *slack.MessageEvent:
+				msg, ok := message(ev)
+				if !ok || t.Self(msg.User) {
+					continue
+				}
+				select {
+				case t.messages <- msg:
+				case <-t.done:
+					return
+				}
+			case *slack.ConnectedEvent:
+				t.connected.Store(true)
+			case *slack.DisconnectedEvent:
+				t.connected.Store(false)
+			case *slack.InvalidAuthEvent:
+				t.logger.Error("invalid credentials")
+				return
+			case *slack.RTMError:
+				t.logger.Error("rtm error", "err", ev)
+			}
+		case <-t.done:
+			return
+		}
+	}
+}
+
+// message converts a message event, following edits and deletes of
+// earlier messages. Edits that don't change the text, like link previews
+// being added, are skipped, as are subtypes that aren't somebody saying
+// something. Messages from bots are marked, and any without a user get
+// the bot's ID instead
+func message(ev *slack.MessageEvent) (transport.Message, bool) {
+	if !slackweb.Said(ev.SubType) {
+		return transport.Message{}, false
+	}
+	switch ev.SubType {
+	case "message_changed":
+		if ev.SubMessage == nil || ev.PreviousMessage != nil && ev.PreviousMessage.Text == ev.SubMessage.Text {
+			return transport.Message{}, false
+		}
+		return transport.Message{
+			Text:      ev.SubMessage.Text,
+			User:      user(ev.SubMessage.User, ev.SubMessage.BotID),
+			Channel:   ev.Channel,
+			Thread:    ev.SubMessage.ThreadTimestamp,
+			Timestamp: ev.SubMessage.Timestamp,
+			Edited:    true,
+			Bot:       ev.SubMessage.BotID != "",
+		}, true
+	case "message_deleted":
+		return transport.Message{
+			Channel:   ev.Channel,
+			Timestamp: ev.DeletedTimestamp,
+			Deleted:   true,
+		}, true
+	}
+	return transport.Message{
+		Text:      ev.Text,
+		User:      user(ev.User, ev.BotID),
+		Channel:   ev.Channel,
+		Thread:    ev.ThreadTimestamp,
+		Timestamp: ev.Timestamp,
+		Bot:       ev.BotID != "" || ev.SubType == "bot_message",
+	}, true
+}
+
+func user(user, bot string) string {
+	if user == "" {
+		return bot
+	}
+	return user
+}
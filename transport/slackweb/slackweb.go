@@ -0,0 +1,244 @@
+// Package slackweb sends replies through Slack's web API. Both Slack
+// transports use it, RTM and the Events API only differ in how messages
+// arrive
+package slackweb
+
+import (
+	"container/list"
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/komon/gosukebot/transport"
+	"github.com/komon/gosukebot/transport/blockkit"
+	"github.com/nlopes/slack"
+)
+
+const (
+	// how many messages' replies are remembered for editing
+	tracked = 1000
+	// how many users' and channels' names are remembered
+	named = 5000
+	// names are looked up again after this long in case they've changed,
+	// or after nameRetry if the lookup failed
+	nameExpiry = time.Hour
+	nameRetry  = time.Minute
+	// how long looking up the names for a message can take before it
+	// goes without them
+	lookupTimeout = 3 * time.Second
+)
+
+// Poster sends, edits and deletes replies, it satisfies the Send part of
+// transport.Transport, all of transport.Editor and transport.Namer
+type Poster struct {
+	api     *slack.Client
+	sent    *transport.Tracker
+	self    string
+	buttons bool
+
+	// names are the most recently used names, oldest first in order
+	nmu   sync.Mutex
+	names map[string]*list.Element
+	order *list.List
+}
+
+// name is a user's or channel's name as looked up, and when to look it
+// up again
+type name struct {
+	id    string
+	name  string
+	until time.Time
+}
+
+// New returns a Poster sending with api
+func New(api *slack.Client) *Poster {
+	return &Poster{
+		api:   api,
+		sent:  transport.NewTracker(tracked),
+		names: map[string]*list.Element{},
+		order: list.New(),
+	}
+}
+
+// EnableButtons has the Poster offer buttons to click on when a card
+// lookup could have meant several cards, for transports that get told
+// about the clicks. Without them the choices are listed as text
+func (p *Poster) EnableButtons() {
+	p.buttons = true
+}
+
+// Identify looks up the bot's own user ID with auth.test, so its own
+// messages can be told apart from everyone else's
+func (p *Poster) Identify() error {
+	resp, err := p.api.AuthTest()
+	if err != nil {
+		return err
+	}
+	p.self = resp.UserID
+	return nil
+}
+
+// Self reports whether user is the bot itself
+func (p *Poster) Self(user string) bool {
+	return user != "" && user == p.self
+}
+
+// Send posts a reply with chat.postMessage, rendering any cards in it as
+// blocks. Ephemeral replies go through chat.postEphemeral, and since they
+// can't be changed afterwards they aren't tracked
+func (p *Poster) Send(r transport.Reply) error {
+	if r.Ephemeral {
+		_, err := p.api.PostEphemeral(r.Channel, r.User, p.options(r)...)
+		return sendError(err)
+	}
+	_, ts, err := p.api.PostMessage(r.Channel, p.options(r)...)
+	if err != nil {
+		return sendError(err)
+	}
+	if r.Revisable {
+		p.sent.Add(r.Channel, r.Source, ts)
+	}
+	return nil
+}
+
+// Edit updates the reply to r.Source with chat.update, deleting any extra
+// messages it was split into. If there's no reply to update it posts one
+func (p *Poster) Edit(r transport.Reply) error {
+	if r.Ephemeral {
+		return p.Send(r)
+	}
+	ids := p.sent.Take(r.Channel, r.Source)
+	if len(ids) == 0 {
+		return p.Send(r)
+	}
+	_, ts, _, err := p.api.UpdateMessage(r.Channel, ids[0], blockkit.MsgOptions(r.Response, p.buttons)...)
+	if err != nil {
+		for _, id := range ids {
+			p.sent.Add(r.Channel, r.Source, id)
+		}
+		return sendError(err)
+	}
+	p.sent.Add(r.Channel, r.Source, ts)
+	return p.remove(r.Channel, ids[1:])
+}
+
+// Delete removes every message sent in reply to source
+func (p *Poster) Delete(channel, source string) error {
+	return p.remove(channel, p.sent.Take(channel, source))
+}
+
+func (p *Poster) remove(channel string, ids []string) error {
+	for _, id := range ids {
+		if _, _, err := p.api.DeleteMessage(channel, id); err != nil {
+			return sendError(err)
+		}
+	}
+	return nil
+}
+
+// Name fills in msg's UserName and ChannelName, looking up the user's
+// display name with users.info and the channel's with conversations.info.
+// Names are cached, and left empty when they can't be looked up, like for
+// bots that aren't users or direct messages, which have no name. Deleted
+// messages aren't answered, so they aren't looked up
+func (p *Poster) Name(msg *transport.Message) {
+	if msg.Deleted {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), lookupTimeout)
+	defer cancel()
+	if msg.User != "" && !(msg.Bot && strings.HasPrefix(msg.User, "B")) {
+		msg.UserName = p.cached(msg.User, func() (string, error) {
+			u, err := p.api.GetUserInfoContext(ctx, msg.User)
+			if err != nil {
+				return "", err
+			}
+			for _, n := range []string{u.Profile.DisplayName, u.RealName} {
+				if n != "" {
+					return n, nil
+				}
+			}
+			return u.Name, nil
+		})
+	}
+	if msg.Channel != "" {
+		msg.ChannelName = p.cached(msg.Channel, func() (string, error) {
+			ch, err := p.api.GetConversationInfoContext(ctx, msg.Channel, false)
+			if err != nil {
+				return "", err
+			}
+			return ch.Name, nil
+		})
+	}
+}
+
+// cached returns the name for id, calling lookup if it isn't known or is
+// out of date. A failed lookup keeps whatever name there was, and isn't
+// tried again for a while so every message doesn't have to wait on it.
+// Only the most recently used names are kept
+func (p *Poster) cached(id string, lookup func() (string, error)) string {
+	now := time.Now()
+	p.nmu.Lock()
+	n := name{id: id}
+	if e, ok := p.names[id]; ok {
+		p.order.MoveToBack(e)
+		n = *e.Value.(*name)
+	}
+	p.nmu.Unlock()
+	if now.Before(n.until) {
+		return n.name
+	}
+	if s, err := lookup(); err == nil {
+		n.name, n.until = s, now.Add(nameExpiry)
+	} else {
+		n.until = now.Add(nameRetry)
+	}
+	p.remember(n)
+	return n.name
+}
+
+// remember caches n, forgetting the least recently used name if there are
+// too many
+func (p *Poster) remember(n name) {
+	p.nmu.Lock()
+	defer p.nmu.Unlock()
+	if e, ok := p.names[n.id]; ok {
+		*e.Value.(*name) = n
+		p.order.MoveToBack(e)
+		return
+	}
+	p.names[n.id] = p.order.PushBack(&n)
+	if p.order.Len() > named {
+		oldest := p.order.Remove(p.order.Front()).(*name)
+		delete(p.names, oldest.id)
+	}
+}
+
+// Said reports whether a message of subtype is somebody saying something,
+// as opposed to joins, topic changes and the like, which aren't handled
+func Said(subtype string) bool {
+	switch subtype {
+	case "", "bot_message", "me_message", "thread_broadcast", "file_share",
+		"message_changed", "message_deleted":
+		return true
+	}
+	return false
+}
+
+func (p *Poster) options(r transport.Reply) []slack.MsgOption {
+	opts := blockkit.MsgOptions(r.Response, p.buttons)
+	if r.Thread != "" {
+		opts = append(opts, slack.MsgOptionTS(r.Thread))
+	}
+	return opts
+}
+
+// sendError turns slack's rate limit errors into the transport's, so
+// they can be retried
+func sendError(err error) error {
+	if rl, ok := err.(*slack.RateLimitedError); ok {
+		return &transport.RateLimitError{RetryAfter: rl.RetryAfter}
+	}
+	return err
+}
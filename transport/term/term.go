@@ -0,0 +1,75 @@
+package term
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os/user"
+	"strconv"
+	"time"
+
+	"github.com/komon/gosukebot/transport"
+)
+
+// Channel is the channel name given to every message read from the
+// terminal
+const Channel = "repl"
+
+// Transport satisfies the transport.Transport interface by reading
+// messages a line at a time and printing replies, it's meant for trying
+// out handlers from a terminal without connecting to a chat system
+type Transport struct {
+	in       io.Reader
+	out      io.Writer
+	user     string
+	messages chan transport.Message
+}
+
+// New returns a Transport reading messages from in and writing replies to
+// out
+func New(in io.Reader, out io.Writer) *Transport {
+	name := "repl"
+	if u, err := user.Current(); err == nil {
+		name = u.Username
+	}
+	return &Transport{
+		in:       in,
+		out:      out,
+		user:     name,
+		messages: make(chan transport.Message),
+	}
+}
+
+// Connect starts reading lines, the Messages channel is closed when the
+// input runs out
+func (t *Transport) Connect() error {
+	go func() {
+		defer close(t.messages)
+		scanner := bufio.NewScanner(t.in)
+		for scanner.Scan() {
+			t.messages <- transport.Message{
+				Text:      scanner.Text(),
+				User:      t.user,
+				Channel:   Channel,
+				Timestamp: strconv.FormatInt(time.Now().UnixNano(), 10),
+			}
+		}
+	}()
+	return nil
+}
+
+// Messages returns the channel lines read from the input arrive on
+func (t *Transport) Messages() <-chan transport.Message {
+	return t.messages
+}
+
+// Send prints the reply
+func (t *Transport) Send(r transport.Reply) error {
+	_, err := fmt.Fprintln(t.out, r.Text)
+	return err
+}
+
+// Close is a no-op, the transport stops once its input does
+func (t *Transport) Close() error {
+	return nil
+}
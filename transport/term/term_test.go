@@ -0,0 +1,30 @@
+package term
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/komon/gosukebot/transport"
+)
+
+func TestTerm(t *testing.T) {
+	out := &bytes.Buffer{}
+	tr := New(strings.NewReader("[[Lightning Bolt]]\n#[[color: r]]\n"), out)
+	tr.Connect()
+
+	var texts []string
+	for msg := range tr.Messages() {
+		if msg.Channel != Channel {
+			t.Errorf("unexpected channel %q", msg.Channel)
+		}
+		texts = append(texts, msg.Text)
+		tr.Send(transport.ReplyTo(msg, transport.Response{Text: "Card Not Found!"}))
+	}
+	if len(texts) != 2 || texts[0] != "[[Lightning Bolt]]" || texts[1] != "#[[color: r]]" {
+		t.Errorf("unexpected messages %q", texts)
+	}
+	if out.String() != "Card Not Found!\nCard Not Found!\n" {
+		t.Errorf("unexpected output %q", out.String())
+	}
+}
@@ -0,0 +1,58 @@
+package transport
+
+import (
+	"container/list"
+	"sync"
+)
+
+// Tracker remembers the IDs of the replies an Editor has sent to each
+// message. Only the most recent messages are remembered, older replies
+// can't be edited any more
+type Tracker struct {
+	mu      sync.Mutex
+	max     int
+	replies map[string]*list.Element
+	order   *list.List
+}
+
+type tracked struct {
+	key string
+	ids []string
+}
+
+// NewTracker returns a Tracker that remembers replies to up to max
+// messages
+func NewTracker(max int) *Tracker {
+	return &Tracker{max: max, replies: map[string]*list.Element{}, order: list.New()}
+}
+
+// Add records id as a reply to source in channel
+func (t *Tracker) Add(channel, source, id string) {
+	if source == "" || id == "" {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	key := channel + "\x00" + source
+	if e, ok := t.replies[key]; ok {
+		e.Value.(*tracked).ids = append(e.Value.(*tracked).ids, id)
+		return
+	}
+	t.replies[key] = t.order.PushBack(&tracked{key, []string{id}})
+	if t.order.Len() > t.max {
+		oldest := t.order.Remove(t.order.Front()).(*tracked)
+		delete(t.replies, oldest.key)
+	}
+}
+
+// Take returns the replies to source in channel and forgets them
+func (t *Tracker) Take(channel, source string) []string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	e, ok := t.replies[channel+"\x00"+source]
+	if !ok {
+		return nil
+	}
+	delete(t.replies, e.Value.(*tracked).key)
+	return t.order.Remove(e).(*tracked).ids
+}
@@ -0,0 +1,137 @@
+package transport
+
+import (
+	"fmt"
+	"time"
+)
+
+// Message is a single chat message received over a Transport, along with
+// enough information about where it came from to reply to it. Timestamp
+// identifies the message in its channel. Edited is set when it's an
+// earlier message that's been changed to Text, and Deleted when an
+// earlier message has been removed. Bot is set when the chat system says
+// the message came from a bot, transports never deliver their own
+// messages. Command is set when the message is a slash command, it's the
+// command's name without the slash and Text is whatever followed it.
+// UserName and ChannelName are what people see instead of the IDs, when
+// the transport knows them. Unverified is set when User is only a name
+// anybody could take, like an IRC nick, so it can't be trusted with
+// anything like admin commands
+type Message struct {
+	Text        string
+	User        string
+	UserName    string
+	Channel     string
+	ChannelName string
+	Thread      string
+	Timestamp   string
+	Edited      bool
+	Deleted     bool
+	Bot         bool
+	Command     string
+	Unverified  bool
+}
+
+// Response is what a handler has to say about a message. Text is always
+// set, it's what transports without rich formatting show. Cards holds the
+// same results in structured form for transports that can do better.
+// Ephemeral responses are only meant for the user who sent the message,
+// transports that can't do that send them to the channel as usual.
+// Revisable is set when some of it came from a handler that redoes its
+// answer when the message is edited. Notes is the part of Text from
+// handlers that had no cards to go with it, which transports rendering
+// Cards instead of Text have to show as well
+type Response struct {
+	Text      string
+	Cards     []Card
+	Notes     string
+	Ephemeral bool
+	Revisable bool
+}
+
+// Reply is a response addressed to somewhere it can be sent back over a
+// Transport. User is who sent the message being replied to, and Source is
+// its Timestamp
+type Reply struct {
+	Response
+	Channel string
+	Thread  string
+	User    string
+	Source  string
+}
+
+// ReplyTo returns a Reply with the given response addressed to the same
+// channel and thread as msg
+func ReplyTo(msg Message, resp Response) Reply {
+	return Reply{
+		Response: resp,
+		Channel:  msg.Channel,
+		Thread:   msg.Thread,
+		User:     msg.User,
+		Source:   msg.Timestamp,
+	}
+}
+
+// Transport is a connection to a chat system. The bot reads incoming
+// messages from it and sends handler responses back through it, so the
+// handlers never need to know which chat system they're talking to
+type Transport interface {
+	// Connect starts the connection, after which incoming messages are
+	// delivered on the Messages channel
+	Connect() error
+	// Messages returns the channel incoming messages are delivered on,
+	// it's closed once the transport is closed
+	Messages() <-chan Message
+	// Send delivers a reply to the chat system
+	Send(Reply) error
+	// Close disconnects from the chat system
+	Close() error
+}
+
+// Editor is implemented by transports that can change replies after
+// they've been sent, so the bot can follow edits to the messages it
+// replied to. Replies are found by the Source they were replying to, and
+// only Revisable ones are kept track of, the rest are left alone
+type Editor interface {
+	// Edit replaces the reply to r.Source in r.Channel with r, or sends r
+	// if there wasn't one
+	Edit(r Reply) error
+	// Delete removes the reply to source in channel, if there was one
+	Delete(channel, source string) error
+}
+
+// Namer is implemented by transports that have to look up the names
+// people see for a message's user and channel. Lookups can be slow, so
+// the bot does them on the worker handling the message rather than as
+// messages arrive, where they'd hold up every other channel
+type Namer interface {
+	// Name fills in msg's UserName and ChannelName, leaving them empty
+	// when they can't be looked up
+	Name(msg *Message)
+}
+
+// Queuer is implemented by transports that send replies in the
+// background, so Send returning nil only means the reply was queued
+type Queuer interface {
+	// Sent has f told how each reply sent or edited went, once it's
+	// really gone out or been given up on
+	Sent(f func(r Reply, err error))
+}
+
+// Checker is implemented by transports that can tell whether they're
+// connected, for health checks
+type Checker interface {
+	// Check returns an error saying what's wrong if the transport isn't
+	// connected, or nil if it is
+	Check() error
+}
+
+// RateLimitError is returned by Send when the chat system wants the bot
+// to back off for RetryAfter before trying again
+type RateLimitError struct {
+	RetryAfter time.Duration
+}
+
+func (e *RateLimitError) Error() string {
+	return fmt.Sprintf("rate limited, retry after %s", e.RetryAfter)
+}